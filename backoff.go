@@ -0,0 +1,568 @@
+package retry
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// BackoffStrategy calculates the backoff between attempts. The
+// default strategy of a Retrier is the exponential backoff with
+// jitter.
+type BackoffStrategy interface {
+	// Backoff returns the duration to sleep before try
+	// number try. Try 0 is the first attempt.
+	Backoff(try int) time.Duration
+}
+
+// ErrorBackoffStrategy is a BackoffStrategy that can also see the
+// error of the failed attempt, for example to back off longer on a
+// 429 Too Many Requests than on a 503 Service Unavailable. The retry
+// loop calls BackoffErr instead of Backoff for the retries, with the
+// latest error of f. Backoff is still used for the wait before the
+// first attempt, when there is no error yet.
+//
+// Example 1:
+//    func (s myStrategy) BackoffErr(try int, err error) time.Duration {
+//        if errors.Is(err, ErrTooManyRequests) {
+//            return 4 * s.Backoff(try)
+//        }
+//        return s.Backoff(try)
+//    }
+type ErrorBackoffStrategy interface {
+	BackoffStrategy
+	// BackoffErr returns the duration to sleep before try
+	// number try, after err.
+	BackoffErr(try int, err error) time.Duration
+}
+
+// WithBackoff sets the strategy for the backoff between attempts,
+// which replaces the exponential backoff configured by WithMaxBackoff,
+// WithRampAttempts, WithJitter and WithRand. A Retrier is only safe
+// for concurrent use if its strategy is.
+func WithBackoff(s BackoffStrategy) Option {
+	return func(r *Retrier) {
+		r.strategy = s
+	}
+}
+
+// Exponential returns the default backoff of a Retrier: it doubles
+// every attempt to reach max within three attempts, with a jitter
+// proportional to the attempt. It panics if max is negative.
+func Exponential(max time.Duration) BackoffStrategy {
+	if max < 0 {
+		panic("retry: exponential max cannot be less than 0")
+	}
+	return exponential{max: max}
+}
+
+// ExponentialBase returns an exponential backoff that starts at base
+// and doubles every attempt up to max, so the sleep before try i is
+// min(base*2^(i-1), max) plus the jitter, instead of deriving the start
+// from max. It is the strategy of WithMinBackoff. It panics if base is
+// not positive or is greater than max.
+//
+// Example 1:
+//    // 100ms, 200ms, 400ms, ... 30s.
+//    r := retry.New(retry.WithBackoff(retry.ExponentialBase(100*time.Millisecond, 30*time.Second)))
+func ExponentialBase(base, max time.Duration) BackoffStrategy {
+	if base <= 0 {
+		panic("retry: exponential base must be greater than 0")
+	}
+	if base > max {
+		panic("retry: exponential base cannot be greater than max")
+	}
+	return exponential{max: max, min: base}
+}
+
+// Constant returns a backoff of d between every attempt, without
+// the ramp-up of the exponential backoff. It panics if d is negative.
+func Constant(d time.Duration) BackoffStrategy {
+	if d < 0 {
+		panic("retry: constant backoff cannot be less than 0")
+	}
+	return constant(d)
+}
+
+type constant time.Duration
+
+func (c constant) Backoff(try int) time.Duration {
+	if try < 1 {
+		return 0
+	}
+	return time.Duration(c)
+}
+
+// Linear returns a backoff that grows by step every attempt, capped
+// at max: the sleep before try i is min(step*i, max). With x retries
+// there are x sleeps, so for step=1s, max=3s and x=4 the sleeps are
+// 1s, 2s, 3s, 3s. It panics if step is not positive or max is negative.
+func Linear(step, max time.Duration) BackoffStrategy {
+	if step <= 0 {
+		panic("retry: linear step must be greater than 0")
+	}
+	if max < 0 {
+		panic("retry: linear max cannot be less than 0")
+	}
+	return linear{step: step, max: max}
+}
+
+type linear struct {
+	step, max time.Duration
+}
+
+func (l linear) Backoff(try int) time.Duration {
+	if try < 1 {
+		return 0
+	}
+	if time.Duration(try) > l.max/l.step {
+		// beyond max, or would overflow
+		return l.max
+	}
+	return l.step * time.Duration(try)
+}
+
+// Fibonacci returns a backoff that multiplies base by the successive
+// Fibonacci numbers, capped at max: base, base, 2*base, 3*base,
+// 5*base, ... max. It grows gentler than the exponential backoff, but
+// faster than the linear one. It panics if base is not positive or
+// max is negative.
+func Fibonacci(base, max time.Duration) BackoffStrategy {
+	if base <= 0 {
+		panic("retry: fibonacci base must be greater than 0")
+	}
+	if max < 0 {
+		panic("retry: fibonacci max cannot be less than 0")
+	}
+	return fibonacci{base: base, max: max}
+}
+
+type fibonacci struct {
+	base, max time.Duration
+}
+
+func (f fibonacci) Backoff(try int) time.Duration {
+	if try < 1 {
+		return 0
+	}
+	// Iterate the sequence up to try, but stop as soon as
+	// the multiple of base passes max, so it can't overflow.
+	limit := f.max / f.base
+	a, b := time.Duration(1), time.Duration(1)
+	if a > limit {
+		return f.max
+	}
+	for i := 1; i < try; i++ {
+		if b > limit || b < 0 {
+			// the next number is beyond max, or overflowed
+			return f.max
+		}
+		a, b = b, a+b
+	}
+	return f.base * a
+}
+
+// Sequence returns a backoff of the exact durations of ds, by try,
+// starting with the wait before the first attempt, and repeats the
+// last one once ds runs out, like the max of the other strategies. It
+// reproduces known timings, for example of an incident, or in tests.
+// The durations are copied. It panics if ds is empty or any of the
+// durations is negative.
+//
+// Example 1:
+//    // Right away, after 1s, then every 3s.
+//    r := retry.New(retry.WithBackoff(retry.Sequence([]time.Duration{0, time.Second, 3 * time.Second})))
+func Sequence(ds []time.Duration) BackoffStrategy {
+	if len(ds) == 0 {
+		panic("retry: sequence cannot be empty")
+	}
+	for _, d := range ds {
+		if d < 0 {
+			panic("retry: sequence backoff cannot be less than 0")
+		}
+	}
+	return sequence(append([]time.Duration(nil), ds...))
+}
+
+type sequence []time.Duration
+
+func (s sequence) Backoff(try int) time.Duration {
+	if try < 0 {
+		try = 0
+	}
+	if try >= len(s) {
+		return s[len(s)-1]
+	}
+	return s[try]
+}
+
+// defaultRamp is the number of tries to reach the max backoff.
+const defaultRamp = 3
+
+// Jitter is the strategy for the random part of the backoff, which
+// keeps retrying clients from synchronizing with each other. The
+// formulas use base for the exponential backoff before jitter, which
+// is min<<try below the max, and the max after the ramp.
+type Jitter int
+
+const (
+	// JitterProportional adds a random [0, min*try) to the base, and
+	// caps the result at the max. Once the ramp reaches the max there
+	// is no jitter. This is the default.
+	JitterProportional Jitter = iota
+	// JitterNone sleeps exactly base.
+	JitterNone
+	// JitterFull sleeps a random [0, base), the "full jitter" of
+	// the AWS Architecture Blog.
+	JitterFull
+	// JitterEqual sleeps base/2 plus a random [0, base/2), the
+	// "equal jitter" of the AWS Architecture Blog.
+	JitterEqual
+
+	// jitterFactor sleeps base ± a fraction of it, see
+	// WithJitterFactor.
+	jitterFactor
+)
+
+// WithJitter sets the jitter strategy of the backoff.
+func WithJitter(j Jitter) Option {
+	return func(r *Retrier) {
+		if j < JitterProportional || j > JitterEqual {
+			r.setErr(errors.New("unknown jitter"))
+			return
+		}
+		r.jitter = j
+	}
+}
+
+// WithNoJitter disables the jitter, so the backoff is exactly the
+// exponential ramp, and then the max, on every run. It is the same as
+// WithJitter(JitterNone), for tests and batch jobs that need the same
+// timings every time.
+func WithNoJitter() Option {
+	return WithJitter(JitterNone)
+}
+
+// WithJitterFactor sets the jitter to a random ±fraction of the base,
+// for example 0.2 for ±20%, which is easier to reason about than the
+// default proportional jitter. It replaces the strategy of WithJitter.
+// The jitter is applied before capping at the max backoff, so once the
+// ramp reaches the max, the backoff only jitters down, unless WithCap
+// leaves some room above the max. A fraction of 0 disables the jitter,
+// like JitterNone. The fraction must be between 0 and 1.
+func WithJitterFactor(fraction float64) Option {
+	return func(r *Retrier) {
+		if !(fraction >= 0 && fraction <= 1) {
+			r.setErr(errors.New("jitter factor must be between 0 and 1"))
+			return
+		}
+		r.jitter = jitterFactor
+		r.jitterFactor = fraction
+	}
+}
+
+// WithMultiplicativeJitter is WithJitterFactor, under the name of the
+// jitter of the gRPC retry policy: the base is multiplied by a random
+// factor in [1-fraction, 1+fraction], and clamped to [0, cap], where
+// cap is the max backoff, or WithCap. It mirrors a retry policy of a
+// gRPC service config in the client.
+//
+// Example 1:
+//    // The 0.2 jitter of gRPC, which may go above the max of 10s.
+//    r := retry.New(retry.WithMaxBackoff(10*time.Second), retry.WithCap(12*time.Second), retry.WithMultiplicativeJitter(0.2))
+func WithMultiplicativeJitter(fraction float64) Option {
+	return WithJitterFactor(fraction)
+}
+
+// WithJitterAtMaxOnly only jitters the backoff once the ramp reached
+// the max backoff, so the ramp is the same on every run, which helps
+// to budget the latency of the first retries, while the clients that
+// are all pinned at the max, where a thundering herd is the most
+// likely, are still kept apart. The default JitterProportional, which
+// otherwise doesn't jitter at the max, is JitterEqual there.
+//
+// Example 1:
+//    // 2s, 4s, then a random [4s, 8s).
+//    r := retry.New(retry.WithMaxBackoff(8*time.Second), retry.WithJitterAtMaxOnly())
+func WithJitterAtMaxOnly() Option {
+	return func(r *Retrier) {
+		r.jitterAtMax = true
+	}
+}
+
+// WithMaxJitter limits the jitter to d, so the backoff is never more
+// than d away from the base, whatever the jitter strategy. At the
+// later tries, the proportional jitter can add a large part of the
+// max, and JitterFull can sleep anything down to zero, which makes
+// the timings hard to predict. A small max jitter keeps the backoff
+// close to the exponential curve, while still keeping the clients
+// apart. JitterFull and JitterEqual jitter down from the base, the
+// other strategies up, or both ways for WithJitterFactor. Zero means
+// no limit, which is the default. It must not be negative.
+//
+// Example 1:
+//    // Up to 100ms of jitter, however long the backoff.
+//    r := retry.New(retry.WithMaxJitter(100 * time.Millisecond))
+func WithMaxJitter(d time.Duration) Option {
+	return func(r *Retrier) {
+		if d < 0 {
+			r.setErr(errors.New("max jitter cannot be less than 0"))
+			return
+		}
+		r.maxJitter = d
+	}
+}
+
+// exponential backoff, reaching max in ramp tries.
+type exponential struct {
+	max time.Duration
+	// min is the backoff of the first retry, zero means
+	// it is derived from ramp.
+	min time.Duration
+	// ramp is the number of tries to reach max,
+	// zero means defaultRamp.
+	ramp int
+	// multiplier is the growth per try, zero means 2.
+	multiplier float64
+	jitter     Jitter
+	// cap is the ceiling of the jitter, zero means max.
+	cap time.Duration
+	// factor is the fraction of the jitterFactor jitter.
+	factor float64
+	// maxJitter limits the jitter, zero means no limit.
+	maxJitter time.Duration
+	// atMaxOnly only jitters once the ramp reached max.
+	atMaxOnly bool
+	// rnd is the source of the jitter, nil means
+	// the package's own source.
+	rnd source
+}
+
+// Backoff for try, see the package level backoff. The min is
+// max/2^ramp, so max is reached on try number ramp.
+func (e exponential) Backoff(try int) time.Duration {
+	switch {
+	case try < 1:
+		return 0
+	case e.max == 0:
+		return e.max
+	}
+	rnd := e.rnd
+	if rnd == nil {
+		rnd = defaultSource
+	}
+
+	base, unit, ramped := e.base(try)
+	jitter := e.jitter
+	if e.atMaxOnly {
+		if !ramped {
+			return base
+		}
+		if jitter == JitterProportional {
+			jitter = JitterEqual
+		}
+	}
+	switch jitter {
+	case JitterNone:
+		return base
+	case JitterFull:
+		if d := e.maxJitter; d > 0 && d < base {
+			return base - d + randDuration(rnd, d)
+		}
+		return randDuration(rnd, base)
+	case JitterEqual:
+		if d := e.maxJitter; d > 0 && d < base-base/2 {
+			return base - d + randDuration(rnd, d)
+		}
+		return base/2 + randDuration(rnd, base-base/2)
+	case jitterFactor:
+		factor := e.factor
+		if d := e.maxJitter; d > 0 && factor*float64(base) > float64(d) {
+			factor = float64(d) / float64(base)
+		}
+		return scaleCapped(base, 1+factor*(2*randFloat(rnd)-1), e.ceiling())
+	}
+
+	if ramped {
+		return e.max
+	}
+	// unit*try < max, as base < max
+	jit := int64(unit) * int64(try)
+	if d := int64(e.maxJitter); d > 0 && d < jit {
+		jit = d
+	}
+	return addCapped(base, time.Duration(rnd.Int63n(jit)), e.ceiling())
+}
+
+// ceiling of the backoff after the jitter.
+func (e exponential) ceiling() time.Duration {
+	if e.cap > e.max {
+		return e.cap
+	}
+	return e.max
+}
+
+// base returns the backoff of try before jitter, which doubles every
+// try up to max, the unit of the proportional jitter, and if the ramp
+// reached max.
+func (e exponential) base(try int) (base, unit time.Duration, ramped bool) {
+	if m := e.multiplier; m != 0 && m != 2 {
+		return e.scaled(try, m)
+	}
+	if e.min > 0 {
+		// The first retry waits min, so unit<<1 is
+		// min, the same as without min.
+		unit = e.min / 2
+		if unit == 0 {
+			unit = 1
+		}
+		base = shiftCapped(e.min, try-1, e.max)
+		return base, unit, base == e.max
+	}
+
+	ramp := e.ramp
+	if ramp == 0 {
+		ramp = defaultRamp
+	}
+	unit = e.max >> uint64(ramp)
+	if unit == 0 {
+		// max is below 2^ramp ns, keep the jitter range
+		// non-empty so Int63n doesn't panic.
+		unit = 1
+	}
+	if try >= ramp {
+		return e.max, unit, true
+	}
+	base = unit << uint64(try)
+	if base >= e.max {
+		// unit was rounded up for a tiny max
+		return e.max, unit, true
+	}
+	return base, unit, false
+}
+
+// scaled is base for a multiplier m other than 2, which needs
+// floating point math instead of shifts. Every try is computed from
+// m^try by itself, instead of by multiplying the backoff of the
+// previous try, so the rounding errors don't add up over the tries,
+// and each backoff is rounded to the nearest nanosecond.
+func (e exponential) scaled(try int, m float64) (base, unit time.Duration, ramped bool) {
+	if e.min > 0 {
+		unit = time.Duration(math.Round(float64(e.min) / m))
+		if unit == 0 {
+			unit = 1
+		}
+		base = scaleCapped(e.min, math.Pow(m, float64(try-1)), e.max)
+		return base, unit, base == e.max
+	}
+
+	ramp := e.ramp
+	if ramp == 0 {
+		ramp = defaultRamp
+	}
+	unit = time.Duration(math.Round(float64(e.max) / math.Pow(m, float64(ramp))))
+	if unit == 0 {
+		unit = 1
+	}
+	if try >= ramp {
+		return e.max, unit, true
+	}
+	base = time.Duration(math.Round(float64(e.max) / math.Pow(m, float64(ramp-try))))
+	if base >= e.max {
+		return e.max, unit, true
+	}
+	return base, unit, false
+}
+
+// scaleCapped returns d*f, rounded to the nanosecond, capped at max,
+// without overflowing.
+func scaleCapped(d time.Duration, f float64, max time.Duration) time.Duration {
+	if v := math.Round(float64(d) * f); v < float64(max) {
+		return time.Duration(v)
+	}
+	return max
+}
+
+// shiftCapped returns d<<n capped at max, without overflowing.
+func shiftCapped(d time.Duration, n int, max time.Duration) time.Duration {
+	if n >= 63 || d > max>>uint64(n) {
+		return max
+	}
+	return d << uint64(n)
+}
+
+// addCapped returns a+b capped at max, without overflowing for a
+// max close to the longest time.Duration. a must not exceed max and
+// b must not be negative.
+func addCapped(a, b, max time.Duration) time.Duration {
+	if b > max-a {
+		return max
+	}
+	return a + b
+}
+
+// randDuration returns a random duration in [0, n), or zero if n
+// isn't positive.
+func randDuration(rnd source, n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(rnd.Int63n(int64(n)))
+}
+
+// randFloat returns a random float in [0, 1).
+func randFloat(rnd source) float64 {
+	return float64(rnd.Int63n(1<<53)) / (1 << 53)
+}
+
+// Decorrelated returns a backoff of the "decorrelated jitter" of the
+// AWS Architecture Blog, where each sleep is a random duration between
+// base and three times the previous sleep, capped at cap:
+//    sleep = min(cap, random(base, prev*3))
+// It spreads the load of long-running retry loops better than a
+// memoryless jitter. It panics if base is not positive or if cap
+// is less than base.
+//
+// The strategy remembers the previous sleep, so it isn't safe for
+// concurrent use: each retry loop needs its own. Try 0 resets it.
+// With a Retrier, the random durations come from its WithRand.
+func Decorrelated(base, cap time.Duration) BackoffStrategy {
+	if base <= 0 {
+		panic("retry: decorrelated base must be greater than 0")
+	}
+	if cap < base {
+		panic("retry: decorrelated cap cannot be less than base")
+	}
+	return &decorrelated{base: base, cap: cap, prev: base}
+}
+
+// randBackoff is a BackoffStrategy with a jitter, which a Retrier
+// draws from its own source.
+type randBackoff interface {
+	backoffRand(try int, rnd source) time.Duration
+}
+
+type decorrelated struct {
+	base, cap time.Duration
+	// prev is the previous sleep.
+	prev time.Duration
+}
+
+func (d *decorrelated) Backoff(try int) time.Duration {
+	return d.backoffRand(try, defaultSource)
+}
+
+func (d *decorrelated) backoffRand(try int, rnd source) time.Duration {
+	if try < 1 {
+		d.prev = d.base
+		return 0
+	}
+	hi := d.prev * 3
+	if hi < d.prev || hi > d.cap {
+		// overflowed or beyond the cap
+		hi = d.cap
+	}
+	sleep := d.base + randDuration(rnd, hi-d.base)
+	d.prev = sleep
+	return sleep
+}
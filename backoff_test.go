@@ -0,0 +1,286 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffRamp(t *testing.T) {
+	t.Parallel()
+	const max = 64 * time.Second
+
+	for _, ramp := range []int{1, 3, 6, 8} {
+		e := exponential{max: max, ramp: ramp}
+		min := max >> uint(ramp)
+		for i := 1; i < ramp; i++ {
+			// Below the ramp, the backoff is the doubled
+			// min plus a jitter of up to min*i.
+			d := e.Backoff(i)
+			assert.True(t, d >= min<<uint(i) && d < min<<uint(i)+min*time.Duration(i), "ramp=%d i=%d d=%v", ramp, i, d)
+			assert.True(t, d < max, "ramp=%d i=%d d=%v", ramp, i, d)
+		}
+		// The max is reached on try number ramp.
+		for i := ramp; i < ramp+10; i++ {
+			assert.Equal(t, max, e.Backoff(i), "ramp=%d i=%d", ramp, i)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	rnd := &lockedSource{rnd: rand.New(rand.NewSource(1))}
+	bases := []time.Duration{0, 2 * time.Second, 4 * time.Second, max, max}
+
+	for i := 0; i < 100; i++ {
+		for try := 1; try < len(bases); try++ {
+			base := bases[try]
+
+			d := exponential{max: max, jitter: JitterNone, rnd: rnd}.Backoff(try)
+			assert.Equal(t, base, d, "none try=%d", try)
+
+			d = exponential{max: max, jitter: JitterFull, rnd: rnd}.Backoff(try)
+			assert.True(t, d >= 0 && d < base, "full try=%d d=%v", try, d)
+
+			d = exponential{max: max, jitter: JitterEqual, rnd: rnd}.Backoff(try)
+			assert.True(t, d >= base/2 && d < base, "equal try=%d d=%v", try, d)
+		}
+	}
+
+	// Every strategy waits nothing on try 0.
+	for _, j := range []Jitter{JitterProportional, JitterNone, JitterFull, JitterEqual} {
+		assert.Zero(t, exponential{max: max, jitter: j}.Backoff(0))
+	}
+}
+
+func TestBackoffMultiplierPrecision(t *testing.T) {
+	t.Parallel()
+	const min, max = 100 * time.Millisecond, 24 * time.Hour
+	e := exponential{max: max, min: min, multiplier: 1.1, jitter: JitterNone}
+
+	// The exact series, min*1.1^(try-1), with rational math.
+	exact := new(big.Rat).SetInt64(int64(min))
+	m := big.NewRat(11, 10)
+	for try := 1; try < 100; try++ {
+		want, _ := exact.Float64()
+		if want >= float64(max) {
+			assert.Equal(t, max, e.Backoff(try), "try=%d", try)
+		} else {
+			assert.InDelta(t, want, float64(e.Backoff(try)), 1, "try=%d", try)
+		}
+		exact.Mul(exact, m)
+	}
+
+	// The same with the ramp, where it is max/1.1^(ramp-try).
+	e = exponential{max: max, ramp: 50, multiplier: 1.1, jitter: JitterNone}
+	exact = new(big.Rat).SetInt64(int64(max))
+	for try := 50; try > 0; try-- {
+		want, _ := exact.Float64()
+		assert.InDelta(t, want, float64(e.Backoff(try)), 1, "try=%d", try)
+		exact.Quo(exact, m)
+	}
+}
+
+func TestDecorrelated(t *testing.T) {
+	t.Parallel()
+	const base, cap = 100 * time.Millisecond, 5 * time.Second
+	s := Decorrelated(base, cap)
+
+	for loop := 0; loop < 10; loop++ {
+		assert.Zero(t, s.Backoff(0))
+		for try := 1; try < 100; try++ {
+			d := s.Backoff(try)
+			assert.True(t, d >= base && d <= cap, "try=%d d=%v", try, d)
+		}
+	}
+}
+
+func TestDecorrelatedGrowth(t *testing.T) {
+	t.Parallel()
+	const base, cap = time.Millisecond, time.Hour
+	s := Decorrelated(base, cap)
+
+	// Every sleep is at most three times the previous one.
+	prev := base
+	for try := 1; try < 30; try++ {
+		d := s.Backoff(try)
+		assert.True(t, d <= prev*3, "try=%d d=%v prev=%v", try, d, prev)
+		prev = d
+	}
+}
+
+func TestDecorrelatedRand(t *testing.T) {
+	t.Parallel()
+	schedule := func() []time.Duration {
+		clock := newFakeClock()
+		r := New(
+			WithMaxAttempts(6),
+			WithClock(clock),
+			WithBackoff(Decorrelated(time.Second, time.Minute)),
+			WithRand(rand.New(rand.NewSource(42))),
+		)
+		_ = r.Do(context.Background(), func(context.Context) error {
+			return errors.New("oops")
+		})
+		return clock.Sleeps()
+	}
+
+	// The random sleeps come from the source of the Retrier.
+	assert.Equal(t, schedule(), schedule())
+}
+
+func TestDecorrelatedBad(t *testing.T) {
+	t.Parallel()
+	assert.Panics(t, func() { Decorrelated(0, time.Second) })
+	assert.Panics(t, func() { Decorrelated(time.Second, time.Millisecond) })
+}
+
+func TestExponential(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	s := Exponential(max)
+
+	// The same curve as the default backoff.
+	assert.Zero(t, s.Backoff(0))
+	for i := 1; i < 100; i++ {
+		d := s.Backoff(i)
+		assert.True(t, d > 0 && d <= max)
+	}
+	assert.Equal(t, max, s.Backoff(3))
+	assert.Panics(t, func() { Exponential(-1) })
+}
+
+func TestExponentialBase(t *testing.T) {
+	t.Parallel()
+	const (
+		base = 100 * time.Millisecond
+		max  = 30 * time.Second
+	)
+	s := ExponentialBase(base, max)
+
+	assert.Zero(t, s.Backoff(0))
+	want := base
+	for i := 1; i <= 10; i++ {
+		// base*2^(i-1), plus a jitter of less than base/2*i
+		d := s.Backoff(i)
+		if want >= max {
+			assert.Equal(t, max, d, "try %d", i)
+		} else {
+			assert.True(t, d >= want && d < want+base/2*time.Duration(i), "try %d: d=%v", i, d)
+		}
+		if want *= 2; want > max {
+			want = max
+		}
+	}
+	assert.Panics(t, func() { ExponentialBase(0, max) })
+	assert.Panics(t, func() { ExponentialBase(2*max, max) })
+}
+
+func TestConstant(t *testing.T) {
+	t.Parallel()
+	s := Constant(2 * time.Second)
+	assert.Zero(t, s.Backoff(0))
+	for i := 1; i < 100; i++ {
+		assert.Equal(t, 2*time.Second, s.Backoff(i))
+	}
+	assert.Panics(t, func() { Constant(-1) })
+}
+
+func TestLinear(t *testing.T) {
+	t.Parallel()
+	s := Linear(time.Second, 5*time.Second)
+	assert.Zero(t, s.Backoff(0))
+	for i := 1; i <= 5; i++ {
+		assert.Equal(t, time.Duration(i)*time.Second, s.Backoff(i))
+	}
+	// Capped at max, even for tries that would overflow.
+	for _, i := range []int{6, 1000, math.MaxInt32, math.MaxInt} {
+		assert.Equal(t, 5*time.Second, s.Backoff(i))
+	}
+	assert.Panics(t, func() { Linear(0, time.Second) })
+	assert.Panics(t, func() { Linear(time.Second, -1) })
+}
+
+func TestFibonacci(t *testing.T) {
+	t.Parallel()
+	s := Fibonacci(time.Second, time.Minute)
+	assert.Zero(t, s.Backoff(0))
+
+	want := []time.Duration{1, 1, 2, 3, 5, 8, 13, 21}
+	for i, w := range want {
+		assert.Equal(t, w*time.Second, s.Backoff(i+1), "try=%d", i+1)
+	}
+	// 34s, 55s, then capped at 89s > max.
+	assert.Equal(t, 55*time.Second, s.Backoff(10))
+	assert.Equal(t, time.Minute, s.Backoff(11))
+	// Large tries neither overflow nor take long.
+	assert.Equal(t, time.Minute, s.Backoff(math.MaxInt))
+
+	assert.Panics(t, func() { Fibonacci(0, time.Second) })
+	assert.Panics(t, func() { Fibonacci(time.Second, -1) })
+}
+
+func TestSequence(t *testing.T) {
+	t.Parallel()
+	ds := []time.Duration{0, time.Second, 3 * time.Second}
+	s := Sequence(ds)
+	ds[2] = time.Hour
+	want := []time.Duration{0, time.Second, 3 * time.Second, 3 * time.Second, 3 * time.Second}
+	for try, w := range want {
+		assert.Equal(t, w, s.Backoff(try), "try=%d", try)
+	}
+	assert.Equal(t, 3*time.Second, s.Backoff(math.MaxInt))
+
+	// The sleeps of a Retrier, including the first one.
+	clock := newFakeClock()
+	r := New(WithMaxAttempts(4), WithBackoff(Sequence([]time.Duration{time.Millisecond, time.Second})), WithClock(clock))
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Second, time.Second, time.Second}, clock.Sleeps())
+
+	assert.Panics(t, func() { Sequence(nil) })
+	assert.Panics(t, func() { Sequence([]time.Duration{time.Second, -1}) })
+}
+
+func TestFibonacciOverflow(t *testing.T) {
+	t.Parallel()
+	const max = time.Duration(math.MaxInt64)
+	s := Fibonacci(1, max)
+	for i := 1; i < 200; i++ {
+		d := s.Backoff(i)
+		assert.True(t, d > 0 && d <= max, "try=%d d=%v", i, d)
+	}
+}
+
+func TestBackoffOverflow(t *testing.T) {
+	t.Parallel()
+	const max = time.Duration(math.MaxInt64)
+
+	for _, j := range []Jitter{JitterProportional, JitterNone, JitterFull, JitterEqual} {
+		e := exponential{max: max, jitter: j}
+		for i := 0; i < 100; i++ {
+			for try := 1; try <= 3; try++ {
+				d := e.Backoff(try)
+				assert.True(t, d >= 0 && d <= max, "jitter=%d try=%d d=%v", j, try, d)
+			}
+		}
+	}
+	assert.Equal(t, max, backoff(3, max))
+}
+
+func TestAddCapped(t *testing.T) {
+	t.Parallel()
+	const max = time.Duration(math.MaxInt64)
+	assert.Equal(t, 3*time.Second, addCapped(time.Second, 2*time.Second, max))
+	assert.Equal(t, 2*time.Second, addCapped(time.Second, 2*time.Second, 2*time.Second))
+	assert.Equal(t, max, addCapped(max-1, max, max))
+	assert.Equal(t, max, addCapped(max, 0, max))
+}
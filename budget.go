@@ -0,0 +1,108 @@
+package retry
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// budgetWindow is the number of calls whose deposits a Budget keeps,
+// so a long healthy period doesn't save up for a retry storm.
+const budgetWindow = 1000
+
+// ErrRetryThrottled is returned, wrapped together with the last error
+// of f, when a Budget has no tokens left for a retry.
+var ErrRetryThrottled = errors.New("retry throttled by budget")
+
+// Budget limits the retries of all the Retriers that share it, so
+// hundreds of retry loops can't amplify the traffic to a struggling
+// downstream, the classic retry storm. It is the retry budget of
+// Finagle: every call of Do deposits ratio tokens, and every retry
+// withdraws one. On top of that, a reserve of minPerSec retries per
+// second is always allowed, so low traffic can still retry. It is not
+// the retry throttling of gRPC, whose failures cost a token, whose
+// successes earn back a ratio of one, and which retries while its
+// bucket is above half full, so its settings don't carry over to
+// NewBudget. When the budget runs out, the retry loop gives up
+// instead of sleeping, and returns the last error of f wrapped with
+// ErrRetryThrottled. A Budget is safe for concurrent use.
+//
+// Example 1:
+//    // Retry at most 10% of the calls, plus 5 retries per second.
+//    budget := retry.NewBudget(0.1, 5)
+//    r := retry.New(retry.WithBudget(budget))
+type Budget struct {
+	ratio     float64
+	minPerSec int
+	clock     Clock
+
+	mu sync.Mutex
+	// tokens deposited by calls, up to ratio*budgetWindow.
+	tokens float64
+	// reserve of the minPerSec retries, up to minPerSec.
+	reserve float64
+	// last refill of the reserve
+	last time.Time
+}
+
+// NewBudget of retries for ratio of the calls, plus minPerSec retries
+// per second. It panics if ratio is not between 0 and 1, or minPerSec
+// is negative.
+func NewBudget(ratio float64, minPerSec int) *Budget {
+	if !(ratio >= 0 && ratio <= 1) {
+		panic("retry: budget ratio must be between 0 and 1")
+	}
+	if minPerSec < 0 {
+		panic("retry: budget min per second cannot be less than 0")
+	}
+	return &Budget{ratio: ratio, minPerSec: minPerSec, reserve: float64(minPerSec)}
+}
+
+// WithBudget limits the retries of the Retrier by b, which can be
+// shared with other Retriers.
+func WithBudget(b *Budget) Option {
+	return func(r *Retrier) {
+		r.budget = b
+	}
+}
+
+// deposit the tokens of a call.
+func (b *Budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if max := b.ratio * budgetWindow; b.tokens > max {
+		b.tokens = max
+	}
+}
+
+// withdraw a token for a retry, and report if there was one.
+func (b *Budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+
+	now := b.getClock().Now()
+	if !b.last.IsZero() {
+		b.reserve += now.Sub(b.last).Seconds() * float64(b.minPerSec)
+		if max := float64(b.minPerSec); b.reserve > max {
+			b.reserve = max
+		}
+	}
+	b.last = now
+	if b.reserve >= 1 {
+		b.reserve--
+		return true
+	}
+	return false
+}
+
+func (b *Budget) getClock() Clock {
+	if b.clock == nil {
+		return realClock{}
+	}
+	return b.clock
+}
@@ -0,0 +1,105 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	b := NewBudget(0.5, 1)
+	b.clock = clock
+
+	// Two calls deposit one token, and the reserve has one more.
+	b.deposit()
+	b.deposit()
+	assert.True(t, b.withdraw())
+	assert.True(t, b.withdraw())
+	assert.False(t, b.withdraw())
+
+	// The reserve refills over time, but only up to minPerSec.
+	clock.After(10 * time.Second)
+	assert.True(t, b.withdraw())
+	assert.False(t, b.withdraw())
+}
+
+func TestBudgetWindow(t *testing.T) {
+	t.Parallel()
+	b := NewBudget(0.1, 0)
+	for i := 0; i < 10*budgetWindow; i++ {
+		b.deposit()
+	}
+	n := 0
+	for b.withdraw() {
+		n++
+	}
+	assert.Equal(t, budgetWindow/10, n)
+}
+
+func TestWithBudget(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	b := NewBudget(0, 2)
+	b.clock = newFakeClock()
+	r := New(WithMaxAttempts(5), WithMaxBackoff(0), WithBudget(b))
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	// The reserve allows two retries, then the loop gives up.
+	assert.True(t, errors.Is(err, ErrRetryThrottled))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.False(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 3, n)
+
+	// And the budget is shared with the next call.
+	n = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrRetryThrottled))
+	assert.Equal(t, 1, n)
+}
+
+func TestWithBudgetConcurrent(t *testing.T) {
+	t.Parallel()
+	b := NewBudget(0.1, 0)
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0), WithBudget(b))
+
+	var (
+		mu    sync.Mutex
+		calls int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = r.Do(context.Background(), func(context.Context) error {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				return errors.New("oops")
+			})
+		}()
+	}
+	wg.Wait()
+	// 100 first attempts, and at most 10 retries.
+	assert.True(t, calls >= 100 && calls <= 110, "calls=%d", calls)
+}
+
+func TestNewBudgetBad(t *testing.T) {
+	t.Parallel()
+	assert.Panics(t, func() { NewBudget(-0.1, 0) })
+	assert.Panics(t, func() { NewBudget(1.1, 0) })
+	assert.Panics(t, func() { NewBudget(0.1, -1) })
+}
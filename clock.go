@@ -0,0 +1,129 @@
+package retry
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of a Retrier. The default uses the
+// system clock, tests can supply a fake one that advances instantly
+// to assert on exact backoff sequences without waiting for them.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends
+	// the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock used when none is configured.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock sets the Clock used to wait between attempts and to
+// measure elapsed time.
+func WithClock(c Clock) Option {
+	return func(r *Retrier) {
+		r.clock = c
+	}
+}
+
+// getClock returns the configured Clock, or the system clock.
+func (r *Retrier) getClock() Clock {
+	if r.clock == nil {
+		return realClock{}
+	}
+	return r.clock
+}
+
+// ready is a wait that is already over.
+var ready = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+// spin busy-waits for d, and reports false if ctx was done first.
+func spin(ctx context.Context, d time.Duration) bool {
+	for start := time.Now(); time.Since(start) < d; {
+		if ctx.Err() != nil {
+			return false
+		}
+		runtime.Gosched()
+	}
+	return true
+}
+
+// waiter waits for the backoffs of one retry loop.
+type waiter interface {
+	// after returns a channel that receives once d elapsed.
+	// It replaces the channel of the previous call.
+	after(d time.Duration) <-chan time.Time
+	// stop releases the waiter.
+	stop()
+}
+
+// newWaiter for the backoffs of c. The system clock reuses pooled
+// timers, across retry loops too, instead of allocating a timer for
+// every backoff.
+func newWaiter(c Clock) waiter {
+	if _, ok := c.(realClock); ok {
+		return timerPool.Get().(*timerWaiter)
+	}
+	return clockWaiter{c}
+}
+
+// clockWaiter waits with the After of a Clock.
+type clockWaiter struct {
+	clock Clock
+}
+
+func (w clockWaiter) after(d time.Duration) <-chan time.Time {
+	return w.clock.After(d)
+}
+
+func (clockWaiter) stop() {}
+
+var timerPool = sync.Pool{
+	New: func() any {
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		return &timerWaiter{timer: t}
+	},
+}
+
+// timerWaiter waits with a reusable timer.
+type timerWaiter struct {
+	timer *time.Timer
+}
+
+func (w *timerWaiter) after(d time.Duration) <-chan time.Time {
+	w.halt()
+	w.timer.Reset(d)
+	return w.timer.C
+}
+
+func (w *timerWaiter) stop() {
+	w.halt()
+	timerPool.Put(w)
+}
+
+// halt stops the timer, and drains its channel if it fired but
+// wasn't received, so it can be reset.
+func (w *timerWaiter) halt() {
+	if !w.timer.Stop() {
+		select {
+		case <-w.timer.C:
+		default:
+		}
+	}
+}
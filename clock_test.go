@@ -0,0 +1,95 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock advances instantly by the duration passed to After,
+// and records every duration it was asked to wait.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Sleeps returns the waits recorded so far.
+func (c *fakeClock) Sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+func TestWithClock(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	var slept []time.Duration
+	r := New(
+		WithMaxAttempts(5),
+		WithMaxBackoff(time.Hour),
+		WithClock(clock),
+		WithOnRetry(func(_ int, _ error, next time.Duration) {
+			slept = append(slept, next)
+		}),
+	)
+
+	// An hour of backoff finishes instantly with a fake clock.
+	err := r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.Error(t, err)
+
+	sleeps := clock.Sleeps()
+	// The first attempt doesn't wait.
+	assert.Equal(t, time.Duration(0), sleeps[0])
+	// The waits are the backoffs reported to the hook.
+	assert.Equal(t, slept, sleeps[1:len(slept)+1])
+	assert.Equal(t, time.Hour, slept[3])
+}
+
+func TestWithClockMaxElapsed(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(
+		WithMaxAttempts(100),
+		WithMaxBackoff(time.Minute),
+		WithMaxElapsedTime(5*time.Minute+30*time.Second),
+		WithClock(newFakeClock()),
+	)
+
+	// The elapsed time is measured by the clock: after the first
+	// attempt the jittered backoffs of at least 15s and 30s, then
+	// four backoffs of 1m fit into the budget, but the next
+	// minute doesn't.
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+	assert.Equal(t, 7, n)
+}
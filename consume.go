@@ -0,0 +1,55 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// Consume reads the messages of ch until it is closed, and handles
+// each of them with the retries of XWithContext, one at a time. When
+// the retries of a message give up, Consume stops and returns that
+// error, see ConsumeAll to keep going. It returns nil once ch is
+// closed, or the error of ctx when ctx is done first.
+//
+// Example 1:
+//    err := retry.Consume(ctx, jobs, 3, 5*time.Second, func(ctx context.Context, job Job) error {
+//        return job.Run(ctx)
+//    })
+func Consume[T any](ctx context.Context, ch <-chan T, x int, maxBackoff time.Duration, handle func(ctx context.Context, msg T) error) error {
+	return consume(ctx, ch, x, maxBackoff, true, handle)
+}
+
+// ConsumeAll is like Consume, but a message whose retries give up
+// doesn't stop the consumer, it moves on to the next message. Once ch
+// is closed, it returns the first error of the messages that gave up,
+// if any.
+func ConsumeAll[T any](ctx context.Context, ch <-chan T, x int, maxBackoff time.Duration, handle func(ctx context.Context, msg T) error) error {
+	return consume(ctx, ch, x, maxBackoff, false, handle)
+}
+
+func consume[T any](ctx context.Context, ch <-chan T, x int, maxBackoff time.Duration, stop bool, handle func(ctx context.Context, msg T) error) error {
+	r := &Retrier{retries: x, maxBackoff: maxBackoff}
+	var first error
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case msg, ok := <-ch:
+			if !ok {
+				return first
+			}
+			err := r.Do(ctx, func(ctx context.Context) error {
+				return handle(ctx, msg)
+			})
+			if err == nil {
+				continue
+			}
+			if stop || ctx.Err() != nil {
+				return err
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+}
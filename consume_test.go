@@ -0,0 +1,80 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsume(t *testing.T) {
+	t.Parallel()
+	ch := make(chan int, 3)
+	ch <- 1
+	ch <- 2
+	ch <- 3
+	close(ch)
+
+	calls := make(map[int]int)
+	err := Consume(context.Background(), ch, 2, time.Millisecond, func(ctx context.Context, msg int) error {
+		calls[msg]++
+		if calls[msg] < 2 {
+			return errors.New("oops")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 2, 2: 2, 3: 2}, calls)
+}
+
+func TestConsumeGiveUp(t *testing.T) {
+	t.Parallel()
+	var ErrOdd = errors.New("odd")
+	handle := func(calls map[int]int) func(context.Context, int) error {
+		return func(ctx context.Context, msg int) error {
+			calls[msg]++
+			if msg%2 == 1 {
+				return ErrOdd
+			}
+			return nil
+		}
+	}
+	messages := func() <-chan int {
+		ch := make(chan int, 4)
+		for i := 0; i < 4; i++ {
+			ch <- i
+		}
+		close(ch)
+		return ch
+	}
+
+	// The first give-up stops Consume.
+	calls := make(map[int]int)
+	err := Consume(context.Background(), messages(), 1, time.Millisecond, handle(calls))
+	assert.True(t, errors.Is(err, ErrOdd))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, map[int]int{0: 1, 1: 2}, calls)
+
+	// But ConsumeAll handles all of the messages.
+	calls = make(map[int]int)
+	err = ConsumeAll(context.Background(), messages(), 1, time.Millisecond, handle(calls))
+	assert.True(t, errors.Is(err, ErrOdd))
+	assert.Equal(t, map[int]int{0: 1, 1: 2, 2: 1, 3: 2}, calls)
+}
+
+func TestConsumeCancelled(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int)
+	go func() { ch <- 1 }()
+	n := 0
+	err := ConsumeAll(ctx, ch, 1, time.Millisecond, func(ctx context.Context, msg int) error {
+		n++
+		cancel()
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, n)
+}
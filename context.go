@@ -0,0 +1,77 @@
+package retry
+
+import "context"
+
+type attemptKey struct{}
+
+// AttemptFromContext returns the number of the attempt that is
+// running in the context passed to f, starting at 1 for the first
+// call, for example to send it in an X-Retry-Attempt header. It
+// reports false for a context that doesn't come from a retry loop.
+//
+// Example 1:
+//    retry.XWithContext(ctx, 3, 5*time.Second, func(ctx context.Context) error {
+//        n, _ := retry.AttemptFromContext(ctx)
+//        req.Header.Set("X-Retry-Attempt", strconv.Itoa(n))
+//        return Send(ctx, req)
+//    })
+func AttemptFromContext(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(attemptKey{}).(int)
+	return n, ok
+}
+
+// withAttempt returns ctx with the attempt number n.
+func withAttempt(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, n)
+}
+
+type nameKey struct{}
+
+// NameFromContext returns the name of the operation that is running
+// in the context passed to f by Retrier.DoNamed, for example to tag
+// a span. It reports false for a context without a name.
+func NameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(nameKey{}).(string)
+	return name, ok
+}
+
+// withName returns ctx with the name of the operation.
+func withName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, nameKey{}, name)
+}
+
+type retrierKey struct{}
+
+// defaultRetrier is returned by From for a context without a Retrier.
+// It has no gate, so it can't be paused for the whole process.
+var defaultRetrier = &Retrier{retries: defaultMaxAttempts - 1, maxBackoff: defaultMaxBackoff}
+
+// WithRetrier returns a copy of ctx that carries r, so code deep in
+// a call stack can retry with the policy of its caller, see From and
+// DoCtx, without passing r down every function.
+//
+// Example 1:
+//    ctx = retry.WithRetrier(ctx, retry.New(retry.WithMaxAttempts(6)))
+//    ...
+//    err := retry.DoCtx(ctx, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+func WithRetrier(ctx context.Context, r *Retrier) context.Context {
+	return context.WithValue(ctx, retrierKey{}, r)
+}
+
+// From returns the Retrier of ctx set by WithRetrier, or else a
+// package default, the policy of New without options: 4 attempts with
+// a max backoff of 5 seconds. The default is shared by every such
+// context, so it can't be paused: its Pause panics.
+func From(ctx context.Context) *Retrier {
+	if r, ok := ctx.Value(retrierKey{}).(*Retrier); ok && r != nil {
+		return r
+	}
+	return defaultRetrier
+}
+
+// DoCtx runs f with the Retrier of ctx, see From.
+func DoCtx(ctx context.Context, f func(ctx context.Context) error) error {
+	return From(ctx).Do(ctx, f)
+}
@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptFromContext(t *testing.T) {
+	t.Parallel()
+	var got []int
+	_ = XWithContext(context.Background(), 3, 0, func(ctx context.Context) error {
+		n, ok := AttemptFromContext(ctx)
+		assert.True(t, ok)
+		got = append(got, n)
+		return errors.New("oops")
+	})
+	assert.Equal(t, []int{1, 2, 3, 4}, got)
+
+	_, ok := AttemptFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestAttemptFromContextTimeout(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(2), WithMaxBackoff(0), WithPerAttemptTimeout(time.Minute))
+	var got []int
+	_ = r.Do(context.Background(), func(ctx context.Context) error {
+		n, _ := AttemptFromContext(ctx)
+		got = append(got, n)
+		return errors.New("oops")
+	})
+	assert.Equal(t, []int{1, 2}, got)
+}
+
+func TestWithRetrier(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(2), WithMaxBackoff(0))
+	ctx := WithRetrier(context.Background(), r)
+	assert.Same(t, r, From(ctx))
+
+	n := 0
+	err := DoCtx(ctx, func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestFromDefault(t *testing.T) {
+	t.Parallel()
+	r := From(context.Background())
+	assert.Equal(t, defaultMaxAttempts-1, r.retries)
+	assert.Equal(t, defaultMaxBackoff, r.maxBackoff)
+	assert.Same(t, r, From(WithRetrier(context.Background(), nil)))
+
+	n := 0
+	assert.NoError(t, DoCtx(context.Background(), func(context.Context) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, 1, n)
+
+	// The shared default can't pause every DoCtx of the process.
+	assert.Panics(t, func() { From(context.Background()).Pause() })
+}
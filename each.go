@@ -0,0 +1,120 @@
+package retry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EachWithContext runs f for each of items with the retries of
+// XWithContext, with up to concurrency retry loops in parallel, and
+// returns the final errors of the items that failed, by their index
+// in items. The map is empty when all items succeeded. Once ctx is
+// done, the running retry loops stop between attempts, and the items
+// that didn't start yet fail with the error of ctx, without calling
+// f. A concurrency of less than 1 is treated as 1.
+//
+// Example 1:
+//    failed := retry.EachWithContext(ctx, users, 8, 3, 5*time.Second, func(ctx context.Context, u User) error {
+//        return Sync(ctx, u)
+//    })
+//    for i, err := range failed {
+//        log.Printf("sync %s: %v", users[i].Name, err)
+//    }
+func EachWithContext[T any](ctx context.Context, items []T, concurrency, x int, maxBackoff time.Duration, f func(ctx context.Context, item T) error) map[int]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mu     sync.Mutex
+		failed = make(map[int]error)
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, concurrency)
+	)
+	fail := func(i int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[i] = err
+	}
+	for i, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(i, context.Cause(ctx))
+			continue
+		}
+		wg.Add(1)
+		go func(i int, item T) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := XWithContext(ctx, x, maxBackoff, func(ctx context.Context) error {
+				return f(ctx, item)
+			})
+			if err != nil {
+				fail(i, err)
+			}
+		}(i, item)
+	}
+	wg.Wait()
+	return failed
+}
+
+// DoAll runs each of fns with its own retry loop of r, one after the
+// other, and returns their final errors, aligned to fns, nil for the
+// ones that succeeded, for example to warm several caches. Once ctx is
+// done, the fns that didn't start yet fail with the error of ctx,
+// without being called. See DoAllConcurrent to run them in parallel.
+//
+// Example 1:
+//    errs := r.DoAll(ctx, users.Warm, groups.Warm)
+//    if err := errors.Join(errs...); err != nil {
+//        return err
+//    }
+func (r *Retrier) DoAll(ctx context.Context, fns ...func(ctx context.Context) error) []error {
+	return r.DoAllConcurrent(ctx, 1, fns...)
+}
+
+// DoAllConcurrent is like DoAll, but runs up to concurrency of fns in
+// parallel. A concurrency of less than 1 is treated as 1.
+func (r *Retrier) DoAllConcurrent(ctx context.Context, concurrency int, fns ...func(ctx context.Context) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, len(fns))
+	if concurrency == 1 {
+		for i, f := range fns {
+			if ctx.Err() != nil {
+				errs[i] = context.Cause(ctx)
+				continue
+			}
+			errs[i] = r.Do(ctx, f)
+		}
+		return errs
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for i, f := range fns {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = context.Cause(ctx)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, f func(ctx context.Context) error) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			// each goroutine writes its own index
+			errs[i] = r.Do(ctx, f)
+		}(i, f)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,124 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEachWithContext(t *testing.T) {
+	t.Parallel()
+	var ErrOdd = errors.New("odd")
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+	var (
+		running, peak int32
+		mu            sync.Mutex
+		calls         = make(map[int]int)
+	)
+	failed := EachWithContext(context.Background(), items, 3, 2, time.Millisecond, func(ctx context.Context, item int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		calls[item]++
+		mu.Unlock()
+		if item%2 == 1 {
+			return ErrOdd
+		}
+		return nil
+	})
+
+	assert.Len(t, failed, 4)
+	for i, err := range failed {
+		assert.Equal(t, 1, i%2)
+		assert.True(t, errors.Is(err, ErrOdd))
+		assert.Equal(t, 3, calls[i])
+	}
+	assert.Equal(t, 1, calls[0])
+	assert.True(t, peak <= 3, "peak=%d", peak)
+}
+
+func TestEachWithContextCancelled(t *testing.T) {
+	t.Parallel()
+	ctx, cancelFn := context.WithCancel(context.Background())
+	var calls int32
+	failed := EachWithContext(ctx, make([]int, 10), 1, 2, time.Minute, func(ctx context.Context, item int) error {
+		atomic.AddInt32(&calls, 1)
+		cancelFn()
+		return errors.New("oops")
+	})
+	// The first item is cancelled during its backoff, the rest never start.
+	assert.Len(t, failed, 10)
+	for _, err := range failed {
+		assert.True(t, errors.Is(err, context.Canceled))
+	}
+	assert.True(t, atomic.LoadInt32(&calls) <= 2)
+}
+
+func TestDoAll(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0))
+	var calls [3]int32
+	fns := []func(context.Context) error{
+		func(context.Context) error {
+			atomic.AddInt32(&calls[0], 1)
+			return nil
+		},
+		func(context.Context) error {
+			atomic.AddInt32(&calls[1], 1)
+			return ErrOops
+		},
+		func(context.Context) error {
+			// succeeds on the second attempt
+			if atomic.AddInt32(&calls[2], 1) < 2 {
+				return ErrOops
+			}
+			return nil
+		},
+	}
+
+	for _, concurrency := range []int{0, 1, 3} {
+		calls = [3]int32{}
+		errs := r.DoAllConcurrent(context.Background(), concurrency, fns...)
+		assert.Len(t, errs, 3)
+		assert.NoError(t, errs[0])
+		assert.True(t, errors.Is(errs[1], ErrOops))
+		assert.True(t, errors.Is(errs[1], ErrMaxRetriesExceeded))
+		assert.NoError(t, errs[2])
+		assert.Equal(t, [3]int32{1, 3, 2}, calls, "concurrency=%d", concurrency)
+	}
+	assert.Equal(t, []error{nil}, r.DoAll(context.Background(), fns[0]))
+	assert.Empty(t, r.DoAll(context.Background()))
+}
+
+func TestDoAllCancelled(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := New(WithMaxBackoff(0))
+	n := 0
+	errs := r.DoAll(ctx, func(context.Context) error {
+		n++
+		cancel()
+		return nil
+	}, func(context.Context) error {
+		n++
+		return nil
+	})
+	assert.Equal(t, 1, n)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, context.Canceled, errs[1])
+}
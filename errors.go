@@ -0,0 +1,272 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Permanent wraps err to signal that retrying is pointless, for
+// example after a validation failure or a 400 Bad Request. When f
+// returns a permanent error, the retry loop stops immediately and
+// returns err itself, not the wrapper. Permanent(nil) returns nil.
+//
+// Example 1:
+//    retry.XWithContext(ctx, 3, 5*time.Second, func(ctx context.Context) error {
+//        err := DoSomething(ctx)
+//        if errors.Is(err, ErrInvalid) {
+//            return retry.Permanent(err)
+//        }
+//        return err
+//    })
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// asPermanent returns the error wrapped by Permanent in err's
+// chain, if there is one.
+func asPermanent(err error) (error, bool) {
+	if perm, ok := find[*permanentError](err); ok {
+		return perm.err, true
+	}
+	return nil, false
+}
+
+// ErrMaxRetriesExceeded is matched by errors.Is for the error of a
+// retry loop that gave up because it ran out of attempts, as opposed
+// to an error f returned that wasn't retried:
+//    if errors.Is(err, retry.ErrMaxRetriesExceeded) {
+//        cause := errors.Unwrap(err)
+//    }
+var ErrMaxRetriesExceeded = errors.New("max retries exceeded")
+
+// RetryError is returned by a retry loop that gave up, after all
+// attempts failed. It unwraps to the last error, so errors.Is and
+// errors.As still find the error of f.
+type RetryError struct {
+	// Attempts is the number of calls of f.
+	Attempts int
+	// Elapsed is the time since the first attempt started.
+	Elapsed time.Duration
+	// Last error of f.
+	Last error
+	// Name of the operation, see Retrier.DoNamed, empty
+	// if it has none.
+	Name string
+
+	// exceeded is set when the loop ran out of attempts.
+	exceeded bool
+}
+
+func (e *RetryError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("operation %q failed after %d attempts: %v", e.Name, e.Attempts, e.Last)
+	}
+	return fmt.Sprintf("failed after %d attempts: %v", e.Attempts, e.Last)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Last
+}
+
+// Is reports ErrMaxRetriesExceeded when the loop ran out of attempts.
+func (e *RetryError) Is(target error) bool {
+	return e.exceeded && target == ErrMaxRetriesExceeded
+}
+
+// Progress wraps err to signal that the attempt made some progress
+// before it failed, like a connection loop that consumed messages
+// before the connection dropped. The retry loop then restarts the
+// backoff, so the next sleep is the initial, small backoff, instead
+// of staying at the max after a single bad hour. The attempt still
+// counts towards the maximum number of attempts, so long-running
+// loops usually retry forever. Progress(nil) returns nil.
+//
+// Example 1:
+//    retry.XWithContext(ctx, 1000, 5*time.Second, func(ctx context.Context) error {
+//        n, err := Consume(ctx)
+//        if n > 0 {
+//            return retry.Progress(err)
+//        }
+//        return err
+//    })
+func Progress(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &progressError{err: err}
+}
+
+type progressError struct {
+	err error
+}
+
+func (e *progressError) Error() string {
+	return e.err.Error()
+}
+
+func (e *progressError) Unwrap() error {
+	return e.err
+}
+
+// isProgress reports if err's chain has an error wrapped by Progress.
+func isProgress(err error) bool {
+	_, ok := find[*progressError](err)
+	return ok
+}
+
+// RetryAfter wraps err to override the backoff before the next
+// attempt with d, for example with the Retry-After of a 429 Too Many
+// Requests, so the server directs the pace of the retries. A d of 0,
+// or less, retries immediately. The override still counts as an
+// attempt, and is still capped by the deadline of the context.
+// RetryAfter(d, nil) returns nil.
+//
+// Example 1:
+//    retry.XWithContext(ctx, 3, 5*time.Second, func(ctx context.Context) error {
+//        err := DoSomething(ctx)
+//        var throttled *ThrottledError
+//        if errors.As(err, &throttled) {
+//            return retry.RetryAfter(throttled.Wait, err)
+//        }
+//        return err
+//    })
+func RetryAfter(d time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+	if d < 0 {
+		d = 0
+	}
+	return &retryAfterError{err: err, d: d}
+}
+
+// RetryNow wraps err to retry immediately, without the backoff, for
+// example after refreshing an expired token. It is RetryAfter(0, err).
+func RetryNow(err error) error {
+	return RetryAfter(0, err)
+}
+
+type retryAfterError struct {
+	err error
+	d   time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// asRetryAfter returns the backoff of an error wrapped by RetryAfter
+// in err's chain, if there is one.
+func asRetryAfter(err error) (time.Duration, bool) {
+	if after, ok := find[*retryAfterError](err); ok {
+		return after.d, true
+	}
+	return 0, false
+}
+
+// find is errors.As for the wrappers of this package, without the
+// allocation of errors.As's target on every failed attempt.
+func find[E error](err error) (E, bool) {
+	for err != nil {
+		if e, ok := err.(E); ok {
+			return e, true
+		}
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			err = u.Unwrap()
+		case interface{ Unwrap() []error }:
+			for _, err := range u.Unwrap() {
+				if e, ok := find[E](err); ok {
+					return e, true
+				}
+			}
+			err = nil
+		default:
+			err = nil
+		}
+	}
+	var zero E
+	return zero, false
+}
+
+// PanicError is the error of an attempt that panicked, when the
+// retry loop recovers panics with WithRecover.
+type PanicError struct {
+	// Value passed to panic.
+	Value any
+	// Stack of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it is an error, for example a
+// runtime.Error, so it can be found with errors.Is and errors.As.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// PanicStop is a panic value that stops the retries, see Stop.
+type PanicStop struct {
+	// Err returned by the retry loop.
+	Err error
+}
+
+// Stop returns a panic value that stops the retry loop when f panics
+// with it, and the loop returns err, like Permanent(err), instead of
+// retrying a *PanicError. It needs WithRecover, without it the panic
+// goes up the stack as usual. It is an escape hatch for code that
+// uses panics for control flow deep in a call stack, which is
+// discouraged, but supported: returning Permanent from f is clearer.
+// Stop(nil) stops the loop without an error.
+//
+// Example 1:
+//    r := retry.New(retry.WithRecover())
+//    err := r.Do(ctx, func(ctx context.Context) error {
+//        return walk(ctx, tree) // panics with retry.Stop(ErrCorrupt)
+//    })
+func Stop(err error) PanicStop {
+	return PanicStop{Err: err}
+}
+
+// recovering wraps f to return a *PanicError when f panics, or a
+// permanent error for a PanicStop.
+func recovering[T any](f func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (v T, err error) {
+		defer func() {
+			switch p := recover().(type) {
+			case nil:
+			case PanicStop:
+				err = Permanent(p.Err)
+			default:
+				err = &PanicError{Value: p, Stack: debug.Stack()}
+			}
+		}()
+		return f(ctx)
+	}
+}
@@ -0,0 +1,287 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermanentStops(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrBadRequest = errors.New("bad request")
+	err := XWithContext(context.Background(), 4, time.Millisecond, func(context.Context) error {
+		n++
+		if n == 2 {
+			return Permanent(ErrBadRequest)
+		}
+		return errors.New("oops")
+	})
+	assert.Equal(t, 2, n)
+	// The original error is returned, not the wrapper.
+	assert.Equal(t, ErrBadRequest, err)
+	assert.True(t, errors.Is(err, ErrBadRequest))
+}
+
+func TestPermanentWrapped(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrBadRequest = errors.New("bad request")
+	// A permanent error further down the chain also stops
+	// the retries, and the permanent error is returned.
+	err := XWithContext(context.Background(), 4, time.Millisecond, func(context.Context) error {
+		n++
+		return fmt.Errorf("call failed: %w", Permanent(ErrBadRequest))
+	})
+	assert.Equal(t, 1, n)
+	assert.Equal(t, ErrBadRequest, err)
+}
+
+func TestPermanentNil(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, Permanent(nil))
+}
+
+func TestWithRecover(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithRecover())
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		if n < 3 {
+			panic("malformed response")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestWithRecoverExhausted(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(2), WithMaxBackoff(time.Millisecond), WithRecover())
+	err := r.Do(context.Background(), func(context.Context) error {
+		var m map[string]int
+		m["oops"]++
+		return nil
+	})
+
+	var perr *PanicError
+	assert.True(t, errors.As(err, &perr))
+	assert.Contains(t, string(perr.Stack), "TestWithRecoverExhausted")
+	// The runtime error is still there.
+	var rerr runtime.Error
+	assert.True(t, errors.As(err, &rerr))
+	assert.Contains(t, err.Error(), "assignment to entry in nil map")
+}
+
+func TestWithRecoverValue(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(2), WithMaxBackoff(time.Millisecond), WithRecover())
+	v, err := DoValue(context.Background(), r, func(context.Context) (string, error) {
+		n++
+		if n == 1 {
+			panic(errors.New("oops"))
+		}
+		return "ok", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", v)
+}
+
+func TestWithRecoverStop(t *testing.T) {
+	t.Parallel()
+	var ErrCorrupt = errors.New("corrupt")
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithRecover())
+
+	// A stop panic returns its error right away.
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		panic(Stop(ErrCorrupt))
+	})
+	assert.Equal(t, ErrCorrupt, err)
+	assert.Equal(t, 1, n)
+
+	// A normal panic is retried.
+	n = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		panic(ErrCorrupt)
+	})
+	var perr *PanicError
+	assert.True(t, errors.As(err, &perr))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 3, n)
+
+	// Stop(nil) stops without an error.
+	n = 0
+	assert.NoError(t, r.Do(context.Background(), func(context.Context) error {
+		n++
+		panic(Stop(nil))
+	}))
+	assert.Equal(t, 1, n)
+
+	// Without WithRecover, it is a panic like any other.
+	assert.Panics(t, func() {
+		_ = New().Do(context.Background(), func(context.Context) error {
+			panic(Stop(ErrCorrupt))
+		})
+	})
+}
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(4),
+		WithMaxBackoff(8*time.Second),
+		WithJitter(JitterNone),
+		WithClock(clock),
+	)
+
+	// fail, fail, succeed (then fail), fail
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		if n == 3 {
+			return Progress(ErrOops)
+		}
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.Equal(t, 4, n)
+	// The failure after the progress sleeps the initial backoff.
+	assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second, 2 * time.Second}, clock.Sleeps()[:4])
+}
+
+func TestProgressNil(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, Progress(nil))
+}
+
+func TestRetryError(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(3),
+		WithMaxBackoff(8*time.Second),
+		WithJitter(JitterNone),
+		WithClock(clock),
+	)
+	err := r.Do(context.Background(), func(context.Context) error {
+		return ErrOops
+	})
+
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 3, rerr.Attempts)
+	// The 2s and 4s backoffs of the fake clock, and no
+	// backoff after the last attempt.
+	assert.Equal(t, 6*time.Second, rerr.Elapsed)
+	assert.Equal(t, ErrOops, rerr.Last)
+	assert.Equal(t, ErrOops, errors.Unwrap(err))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.EqualError(t, err, "failed after 3 attempts: oops")
+}
+
+func TestRetryErrorBudgetExhausted(t *testing.T) {
+	t.Parallel()
+	r := New(
+		WithMaxAttempts(10),
+		WithMaxBackoff(8*time.Second),
+		WithMaxElapsedTime(10*time.Second),
+		WithJitter(JitterNone),
+		WithClock(newFakeClock()),
+	)
+	err := r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	// 2s and 4s fit into the budget, another 8s doesn't.
+	var rerr *RetryError
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 3, rerr.Attempts)
+	assert.Equal(t, 6*time.Second, rerr.Elapsed)
+}
+
+func TestErrMaxRetriesExceeded(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	err := XWithContext(context.Background(), 2, time.Millisecond, func(context.Context) error {
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.Equal(t, ErrOops, errors.Unwrap(err))
+
+	// Not for errors that stopped the loop without exhausting it.
+	err = XWithContext(context.Background(), 2, time.Millisecond, func(context.Context) error {
+		return Permanent(ErrOops)
+	})
+	assert.False(t, errors.Is(err, ErrMaxRetriesExceeded))
+
+	err = XWithDeadline(context.Background(), 100, 20*time.Millisecond, 30*time.Millisecond, func(context.Context) error {
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+	assert.False(t, errors.Is(err, ErrMaxRetriesExceeded))
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(4),
+		WithMaxBackoff(8*time.Second),
+		WithJitter(JitterNone),
+		WithClock(clock),
+	)
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		switch n++; n {
+		case 1:
+			return RetryNow(ErrOops)
+		case 2:
+			return RetryAfter(30*time.Second, ErrOops)
+		}
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.Equal(t, 4, n)
+	// The overrides replace the backoff of their retry only.
+	assert.Equal(t, []time.Duration{0, 0, 30 * time.Second, 8 * time.Second}, clock.Sleeps()[:4])
+}
+
+func TestRetryAfterNil(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, RetryAfter(time.Second, nil))
+	assert.NoError(t, RetryNow(nil))
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	d, ok := asRetryAfter(fmt.Errorf("wrapped: %w", RetryAfter(time.Second, ErrOops)))
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, d)
+
+	d, ok = asRetryAfter(errors.Join(ErrOops, RetryAfter(2*time.Second, ErrOops)))
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, d)
+
+	_, ok = asRetryAfter(ErrOops)
+	assert.False(t, ok)
+	assert.True(t, isProgress(errors.Join(ErrOops, Progress(ErrOops))))
+}
@@ -0,0 +1,152 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lytics/retry/internal/httpretry"
+)
+
+// HTTPStatusError is the error of an attempt of HTTPDo that got a
+// response with a retryable status code.
+type HTTPStatusError struct {
+	// StatusCode of the response, like 503.
+	StatusCode int
+	// Status of the response, like "503 Service Unavailable".
+	Status string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %s", e.Status)
+}
+
+// HTTPOption configures HTTPDo.
+type HTTPOption func(*httpConfig)
+
+type httpConfig struct {
+	// clampRetryAfter caps the Retry-After at the max backoff.
+	clampRetryAfter bool
+	// retryAll retries the methods that aren't idempotent.
+	retryAll bool
+	// keepLast returns the response of the last failed
+	// attempt, instead of an error.
+	keepLast bool
+}
+
+// WithClampedRetryAfter caps the wait of a Retry-After header at the
+// max backoff of HTTPDo, see ClampRetryAfter, so a misbehaving server,
+// or one whose clock is skewed, can't make HTTPDo sleep for hours.
+func WithClampedRetryAfter() HTTPOption {
+	return func(c *httpConfig) {
+		c.clampRetryAfter = true
+	}
+}
+
+// HTTPDo sends req with client, or http.DefaultClient if client is
+// nil, and retries the connection errors and timeouts of
+// DefaultRetryable and the status codes 429, 500, 502, 503 and 504,
+// with the semantics of XWithContext. The other errors of client are
+// returned without a retry. When a response has a Retry-After header,
+// in seconds or as an HTTP-date, the next attempt waits for it instead
+// of the computed backoff, see RetryAfter, even when it is longer than
+// maxBackoff, unless WithClampedRetryAfter is given. A Retry-After of
+// 0 retries right away.
+//
+// The body of req is rewound with req.GetBody for every attempt, a
+// request with a body but no GetBody is only sent once. The bodies of
+// the responses of failed attempts are drained and closed. If all
+// attempts fail, the error unwraps to the last *HTTPStatusError, or
+// the last error of client.
+//
+// Example 1:
+//    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+//    ...
+//    resp, err := retry.HTTPDo(ctx, nil, req, 3, 5*time.Second)
+//    if err != nil {
+//        return err
+//    }
+//    defer resp.Body.Close()
+func HTTPDo(ctx context.Context, client *http.Client, req *http.Request, x int, maxBackoff time.Duration, opts ...HTTPOption) (*http.Response, error) {
+	var c httpConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpRetry(ctx, req, x, maxBackoff, c, client.Do)
+}
+
+// httpRetry is the retry loop of HTTPDo and Transport, which sends
+// the requests with send.
+func httpRetry(ctx context.Context, req *http.Request, x int, maxBackoff time.Duration, c httpConfig, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if !httpretry.Resendable(req) {
+		// the body can't be sent again
+		x = 0
+	}
+	if maxBackoff < 0 {
+		return nil, errors.New("maxBackoff cannot be less than 0")
+	}
+	r := &Retrier{retries: x, maxBackoff: maxBackoff}
+	return httpretry.Do(ctx, req, httpretry.Policy{
+		Send: send,
+		Retry: func(ctx context.Context, f func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+			return DoValue(ctx, r, f)
+		},
+		Retryable: httpretry.RetryableStatus,
+		Transient: DefaultRetryable,
+		Permanent: Permanent,
+		RetryAfter: func(d time.Duration, err error) error {
+			if c.clampRetryAfter {
+				d = ClampRetryAfter(d, maxBackoff)
+			}
+			return RetryAfter(d, err)
+		},
+		StatusError: newHTTPStatusError,
+		Exhausted: func(err error) bool {
+			return errors.Is(err, ErrMaxRetriesExceeded)
+		},
+		KeepLast: c.keepLast,
+	})
+}
+
+// newHTTPStatusError returns the *HTTPStatusError of resp.
+func newHTTPStatusError(resp *http.Response) error {
+	return &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, either
+// in seconds like "120" or as an HTTP-date like
+// "Fri, 31 Dec 1999 23:59:59 GMT", into the duration to wait from now.
+// It reports false if header can't be parsed. Negative seconds and
+// dates before now are zero.
+func ParseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	return httpretry.ParseRetryAfter(header, now)
+}
+
+// ClampRetryAfter returns the wait d of ParseRetryAfter clamped to
+// [0, max]. The HTTP-date form of Retry-After depends on the clock of
+// the server, which may be skewed from ours, and a misbehaving server
+// may ask for a wait of days, so a wait longer than max is treated as
+// max, rather than blindly sleeping for it.
+//
+// Example 1:
+//    d, ok := retry.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+//    if ok {
+//        d = retry.ClampRetryAfter(d, 30*time.Second)
+//    }
+func ClampRetryAfter(d, max time.Duration) time.Duration {
+	switch {
+	case d < 0:
+		return 0
+	case d > max:
+		return max
+	}
+	return d
+}
+
+// maxDuration is the longest time.Duration.
+const maxDuration = time.Duration(1<<63 - 1)
@@ -0,0 +1,242 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPDo(t *testing.T) {
+	t.Parallel()
+	var n int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	assert.NoError(t, err)
+	resp, err := HTTPDo(context.Background(), srv.Client(), req, 3, time.Millisecond)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "ok", string(b))
+	assert.Equal(t, int32(3), n)
+	// The body was rewound for every attempt.
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+}
+
+func TestHTTPDoExhausted(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := HTTPDo(context.Background(), srv.Client(), req, 2, time.Millisecond)
+	assert.Nil(t, resp)
+	assert.Equal(t, int32(3), n)
+
+	var serr *HTTPStatusError
+	assert.True(t, errors.As(err, &serr))
+	assert.Equal(t, http.StatusBadGateway, serr.StatusCode)
+}
+
+func TestHTTPDoNotRetryable(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := HTTPDo(context.Background(), srv.Client(), req, 2, time.Millisecond)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, int32(1), n)
+}
+
+func TestHTTPDoRetryAfter(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	// The backoff would be a millisecond, but the server asked for a second.
+	resp, err := HTTPDo(context.Background(), srv.Client(), req, 2, time.Millisecond)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.True(t, time.Since(start) >= time.Second)
+}
+
+func TestHTTPDoRetryAfterZero(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	// A Retry-After of 0 retries right away, not after the backoff.
+	resp, err := HTTPDo(context.Background(), srv.Client(), req, 2, time.Minute)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestHTTPDoConnectionError(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := HTTPDo(context.Background(), nil, req, 2, time.Millisecond)
+	assert.Nil(t, resp)
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 3, rerr.Attempts)
+}
+
+func TestHTTPDoClientError(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// An error of the client other than a connection error isn't
+	// retried.
+	errNoRedirect := errors.New("no redirect")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return errNoRedirect
+	}}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := HTTPDo(context.Background(), client, req, 2, time.Millisecond)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	assert.True(t, errors.Is(err, errNoRedirect), "err=%v", err)
+	assert.Equal(t, int32(1), n)
+}
+
+func TestHTTPDoNoGetBody(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// A body that can't be rewound is only sent once.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, io.MultiReader(strings.NewReader("payload")))
+	_, err := HTTPDo(context.Background(), srv.Client(), req, 2, time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), n)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"-5", 0, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+		{"1.5", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.header, now)
+		assert.Equal(t, tt.ok, ok, "header=%q", tt.header)
+		assert.Equal(t, tt.want, got, "header=%q", tt.header)
+	}
+}
+
+func TestHTTPDoClampedRetryAfter(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			// A skewed or broken server asks for a wait of a year.
+			w.Header().Set("Retry-After", time.Now().AddDate(1, 0, 0).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	resp, err := HTTPDo(context.Background(), srv.Client(), req, 2, 10*time.Millisecond, WithClampedRetryAfter())
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestClampRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	const max = 30 * time.Second
+
+	d, ok := ParseRetryAfter(now.AddDate(10, 0, 0).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, max, ClampRetryAfter(d, max))
+
+	d, _ = ParseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now)
+	assert.Equal(t, 10*time.Second, ClampRetryAfter(d, max))
+	assert.Equal(t, max, ClampRetryAfter(maxDuration, max))
+	assert.Zero(t, ClampRetryAfter(-time.Second, max))
+}
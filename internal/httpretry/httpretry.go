@@ -0,0 +1,193 @@
+// Package httpretry is the retry loop of HTTP requests, shared by the
+// HTTPDo and NewTransport of the retry package, and the Client of the
+// retryhttp package. The retry package imports it, so it can't import
+// the retry package: the retry loop and the errors of the retry
+// package are passed in with a Policy.
+package httpretry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy of the retries of a request.
+type Policy struct {
+	// Send sends an attempt of the request.
+	Send func(*http.Request) (*http.Response, error)
+	// Retry runs f until it returns a nil error, like the
+	// DoValue of the retry package.
+	Retry func(ctx context.Context, f func(ctx context.Context) (*http.Response, error)) (*http.Response, error)
+	// Retryable reports if a response with the status code is
+	// retried.
+	Retryable func(code int) bool
+	// Transient reports if an error of Send is retried.
+	Transient func(err error) bool
+	// Permanent wraps an error that stops the retries.
+	Permanent func(err error) error
+	// RetryAfter wraps the error of an attempt whose response
+	// asked to wait for d before the next one.
+	RetryAfter func(d time.Duration, err error) error
+	// StatusError is the error of an attempt with a retryable
+	// response.
+	StatusError func(resp *http.Response) error
+	// Exhausted reports if the error of Retry is the retries
+	// running out of attempts.
+	Exhausted func(err error) bool
+	// KeepLast returns the response of the last attempt when
+	// the retries run out, instead of the error.
+	KeepLast bool
+}
+
+// Do sends req with the retries of p. The body of req is rewound
+// with req.GetBody for every attempt, and the bodies of the responses
+// of failed attempts are drained and closed, but for the last one
+// with KeepLast.
+func Do(ctx context.Context, req *http.Request, p Policy) (*http.Response, error) {
+	// last response of a failed attempt, only kept to
+	// return it when all attempts fail
+	var last *http.Response
+	first := true
+	resp, err := p.Retry(ctx, func(ctx context.Context) (*http.Response, error) {
+		if last != nil {
+			Drain(last)
+			last = nil
+		}
+		areq := req.WithContext(ctx)
+		if !first && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, p.Permanent(err)
+			}
+			areq.Body = body
+		}
+		first = false
+
+		resp, err := p.Send(areq)
+		if err != nil {
+			if !p.Transient(err) {
+				return nil, p.Permanent(err)
+			}
+			return nil, err
+		}
+		if !p.Retryable(resp.StatusCode) {
+			return resp, nil
+		}
+
+		err = p.StatusError(resp)
+		if d, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			err = p.RetryAfter(d, err)
+		}
+		if p.KeepLast {
+			last = resp
+		} else {
+			Drain(resp)
+		}
+		return nil, err
+	})
+	if last != nil && p.Exhausted(err) {
+		return last, nil
+	}
+	if last != nil {
+		Drain(last)
+	}
+	return resp, err
+}
+
+// Resendable reports if req can be sent more than once: it has no
+// body, or req.GetBody to rewind it.
+func Resendable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// RetryableStatus reports if a response with the status code is
+// worth retrying.
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Idempotent reports if req can be sent again, like the retries of
+// the http package do.
+func Idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// Drain drains and closes the body of resp, so the connection can be
+// reused.
+func Drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// maxDuration is the longest time.Duration.
+const maxDuration = time.Duration(1<<63 - 1)
+
+// ParseRetryAfter is the ParseRetryAfter of the retry package.
+func ParseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, true
+		}
+		if secs > int64(maxDuration/time.Second) {
+			return maxDuration, true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	d := date.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+type onRetryKey struct{}
+
+// onRetry is a hook of ctx for the retry loops of owner.
+type onRetry struct {
+	owner any
+	f     func(attempt int, err error, next time.Duration)
+}
+
+// WithOnRetry returns a copy of ctx with f, which replaces the OnRetry
+// hook of the retry loops of owner, a *retry.Retrier, that run with
+// ctx. The other Retriers, like those of the requests sent by f, keep
+// their own.
+func WithOnRetry(ctx context.Context, owner any, f func(attempt int, err error, next time.Duration)) context.Context {
+	return context.WithValue(ctx, onRetryKey{}, onRetry{owner: owner, f: f})
+}
+
+// OnRetry returns the hook of WithOnRetry for the retry loops of
+// owner, or nil.
+func OnRetry(ctx context.Context, owner any) func(attempt int, err error, next time.Duration) {
+	if h, ok := ctx.Value(onRetryKey{}).(onRetry); ok && h.owner == owner {
+		return h.f
+	}
+	return nil
+}
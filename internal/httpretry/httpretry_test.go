@@ -0,0 +1,37 @@
+package httpretry
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnRetry(t *testing.T) {
+	t.Parallel()
+	owner, other := new(int), new(int)
+	n := 0
+	ctx := WithOnRetry(context.Background(), owner, func(int, error, time.Duration) { n++ })
+
+	// Only the retry loops of the owner get the hook.
+	f := OnRetry(ctx, owner)
+	assert.NotNil(t, f)
+	f(1, nil, 0)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, OnRetry(ctx, other))
+	assert.Nil(t, OnRetry(context.Background(), owner))
+}
+
+func TestResendable(t *testing.T) {
+	t.Parallel()
+	get, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	assert.True(t, Resendable(get))
+	// http.NewRequest sets GetBody for a strings.Reader.
+	post, _ := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("payload"))
+	assert.True(t, Resendable(post))
+	post.GetBody = nil
+	assert.False(t, Resendable(post))
+}
@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Iterator returns the successive backoff durations of the package's
+// exponential backoff, for loops that can't cede control to X or
+// XWithContext, like a select over several channels. It keeps the try
+// counter, so the caller only needs to feed Next into its own timer.
+// An Iterator is not safe for concurrent use.
+//
+// Long-lived loops, like a consumer that reconnects after errors,
+// should Reset after making progress, so a single bad hour doesn't max
+// out the backoff of every later error. With a Retrier, f can do the
+// same by returning an error wrapped with Progress.
+//
+// Example 1:
+//    iter := retry.NewIterator(5 * time.Second)
+//    for {
+//        select {
+//        case <-time.After(iter.Next()):
+//            if err := DoSomething(); err == nil {
+//                iter.Reset()
+//            }
+//        case <-stop:
+//            return
+//        }
+//    }
+type Iterator struct {
+	strategy BackoffStrategy
+	max      time.Duration
+	try      int
+}
+
+// NewIterator of the exponential backoff that reaches max within
+// three tries.
+func NewIterator(max time.Duration) *Iterator {
+	return &Iterator{strategy: Exponential(max), max: max}
+}
+
+// Next backoff duration: zero the first time, then the ramp up to
+// the max, then the max forever.
+func (it *Iterator) Next() time.Duration {
+	d := it.strategy.Backoff(it.try)
+	if it.try < Infinite {
+		// stays at the max rather than overflowing
+		it.try++
+	}
+	return d
+}
+
+// Reset restarts the sequence, so the next call of Next returns zero.
+func (it *Iterator) Reset() {
+	it.try = 0
+}
+
+// iteratorStateVersion is the first byte of the state of an Iterator.
+const iteratorStateVersion = 1
+
+// errIteratorState is returned by RestoreIterator for a state it
+// can't read.
+var errIteratorState = errors.New("retry: invalid iterator state")
+
+// MarshalState returns the position of it in the backoff sequence, the
+// number of calls of Next so far and the max backoff, for a durable
+// job to persist, and resume with RestoreIterator after a restart.
+// Then the jobs keep backing off where they were, instead of all
+// retrying at once after a crash. The last backoff isn't part of the
+// state, as the try sets it, give or take the jitter.
+//
+// Example 1:
+//    store.Save(job.ID, iter.MarshalState())
+//    ...
+//    iter, err := retry.RestoreIterator(store.Load(job.ID))
+func (it *Iterator) MarshalState() []byte {
+	b := []byte{iteratorStateVersion}
+	b = binary.AppendUvarint(b, uint64(it.try))
+	return binary.AppendUvarint(b, uint64(it.max))
+}
+
+// RestoreIterator returns an Iterator at the position of state, from
+// MarshalState, so Next continues the backoff sequence.
+func RestoreIterator(state []byte) (*Iterator, error) {
+	if len(state) == 0 || state[0] != iteratorStateVersion {
+		return nil, errIteratorState
+	}
+	state = state[1:]
+	try, n := binary.Uvarint(state)
+	if n <= 0 || try > uint64(Infinite) {
+		return nil, errIteratorState
+	}
+	state = state[n:]
+	max, n := binary.Uvarint(state)
+	if n <= 0 || n != len(state) || max > uint64(maxDuration) {
+		return nil, errIteratorState
+	}
+	it := NewIterator(time.Duration(max))
+	it.try = int(try)
+	return it, nil
+}
@@ -0,0 +1,111 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterator(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	iter := NewIterator(max)
+
+	assert.Zero(t, iter.Next())
+	prev := time.Duration(0)
+	for i := 1; i < 3; i++ {
+		d := iter.Next()
+		assert.True(t, d > prev && d < max, "i=%d d=%v", i, d)
+		prev = d
+	}
+	// Then the max forever.
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, max, iter.Next())
+	}
+}
+
+func TestIteratorReset(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	iter := NewIterator(max)
+	for i := 0; i < 10; i++ {
+		iter.Next()
+	}
+
+	// The sequence restarts from the beginning.
+	iter.Reset()
+	assert.Zero(t, iter.Next())
+	assert.True(t, iter.Next() < max/2)
+}
+
+func TestIteratorConnectionLoop(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	iter := NewIterator(max)
+
+	// fail, fail, succeed, fail
+	var sleeps []time.Duration
+	for _, ok := range []bool{false, false, true, false} {
+		if ok {
+			iter.Reset()
+			continue
+		}
+		sleeps = append(sleeps, iter.Next())
+	}
+	assert.Zero(t, sleeps[0])
+	assert.True(t, sleeps[1] > 0)
+	// After the success the backoff starts over.
+	assert.Zero(t, sleeps[2])
+	assert.True(t, iter.Next() < max/2)
+}
+
+func TestIteratorState(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	iter := NewIterator(max)
+	iter.Next()
+	iter.Next()
+
+	restored, err := RestoreIterator(iter.MarshalState())
+	assert.NoError(t, err)
+	// The ramp continues on the third try, then the max.
+	lo, hi := BackoffBounds(2, max)
+	d := restored.Next()
+	assert.True(t, d >= lo && d <= hi, "d=%v", d)
+	assert.Equal(t, max, restored.Next())
+
+	// The state of a new Iterator starts over.
+	restored, err = RestoreIterator(NewIterator(max).MarshalState())
+	assert.NoError(t, err)
+	assert.Zero(t, restored.Next())
+
+	// And a round trip keeps the state.
+	for _, it := range []*Iterator{NewIterator(0), NewIterator(maxDuration), {max: time.Second, try: Infinite}} {
+		restored, err := RestoreIterator(it.MarshalState())
+		assert.NoError(t, err)
+		assert.Equal(t, it.MarshalState(), restored.MarshalState())
+	}
+}
+
+func TestRestoreIteratorEnd(t *testing.T) {
+	t.Parallel()
+	// A try at the end of the int range stays at the max.
+	it := &Iterator{max: time.Second, try: Infinite - 1}
+	restored, err := RestoreIterator(it.MarshalState())
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, time.Second, restored.Next(), "i=%d", i)
+	}
+	end := &Iterator{max: time.Second, try: Infinite}
+	assert.Equal(t, end.MarshalState(), restored.MarshalState())
+}
+
+func TestRestoreIteratorBad(t *testing.T) {
+	t.Parallel()
+	state := NewIterator(time.Second).MarshalState()
+	for _, bad := range [][]byte{nil, {}, {2, 0, 0}, state[:1], state[:len(state)-1], append(state, 0)} {
+		_, err := RestoreIterator(bad)
+		assert.Error(t, err, "state=%v", bad)
+	}
+}
@@ -0,0 +1,29 @@
+package retry
+
+// Logger is used by a Retrier to log the failed attempts that will be
+// retried, see WithLogger. It is tiny so any logging library can be
+// adapted to it, for example the standard library's log package with
+// LoggerFunc(log.Printf).
+type Logger interface {
+	Retryf(format string, args ...any)
+}
+
+// LoggerFunc adapts a Printf-like function to a Logger.
+type LoggerFunc func(format string, args ...any)
+
+// Retryf calls f.
+func (f LoggerFunc) Retryf(format string, args ...any) {
+	f(format, args...)
+}
+
+// WithLogger logs each failed attempt that will be retried to l, with
+// the number of the attempt, its error and the backoff before the
+// next one. By default nothing is logged.
+//
+// Example 1:
+//    r := retry.New(retry.WithLogger(retry.LoggerFunc(log.Printf)))
+func WithLogger(l Logger) Option {
+	return func(r *Retrier) {
+		r.logger = l
+	}
+}
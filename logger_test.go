@@ -0,0 +1,34 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLogger(t *testing.T) {
+	t.Parallel()
+	var lines []string
+	logger := LoggerFunc(func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	})
+	r := New(
+		WithMaxAttempts(3),
+		WithMaxBackoff(8*time.Second),
+		WithJitter(JitterNone),
+		WithClock(newFakeClock()),
+		WithLogger(logger),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	// The final failure isn't retried, so it isn't logged.
+	assert.Equal(t, []string{
+		"retry: attempt 1 failed: oops, retrying in 2s",
+		"retry: attempt 2 failed: oops, retrying in 4s",
+	}, lines)
+}
@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DialContext connects to address on the named network, see
+// net.Dialer.DialContext, and retries the errors that usually go
+// away, with the semantics of XWithContext: refused and reset
+// connections, and timeouts. A service that is being deployed
+// refuses connections for its first few seconds, but an error like
+// "no such host" is returned without retrying.
+//
+// Example 1:
+//    conn, err := retry.DialContext(ctx, "tcp", "db:5432", 5, time.Second)
+func DialContext(ctx context.Context, network, address string, x int, maxBackoff time.Duration) (net.Conn, error) {
+	var d net.Dialer
+	return DoValue(ctx, &Retrier{retries: x, maxBackoff: maxBackoff, retryable: retryableDialError}, func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, network, address)
+	})
+}
+
+// retryableDialError reports if a dial error is worth retrying.
+func retryableDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		// "no such host" is neither
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// DefaultRetryable is a conservative predicate for network code, to
+// pass to XWithPredicate or WithRetryable. It retries timeouts, a
+// net.Error whose Timeout is true, like the os.ErrDeadlineExceeded of
+// a connection deadline, refused and reset connections,
+// syscall.ECONNREFUSED and syscall.ECONNRESET, and
+// io.ErrUnexpectedEOF, a response cut short. Every other error is not
+// retried, in particular context.Canceled and
+// context.DeadlineExceeded, which are the caller giving up, even
+// though the latter is a timeout, and io.EOF, which is usually a
+// normal end rather than a failure.
+//
+// Example 1:
+//    err := retry.XWithPredicate(ctx, 3, 5*time.Second, retry.DefaultRetryable, func(ctx context.Context) error {
+//        return Fetch(ctx, url)
+//    })
+func DefaultRetryable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialContext(t *testing.T) {
+	t.Parallel()
+	// Find a free address, then only listen on it after the
+	// first attempts got their connections refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	ready := make(chan net.Listener)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			close(ready)
+			return
+		}
+		ready <- l
+	}()
+
+	conn, err := DialContext(context.Background(), "tcp", addr, 10, 10*time.Millisecond)
+	l, ok := <-ready
+	if !ok {
+		t.Skip("address taken before listening again")
+	}
+	defer l.Close()
+	assert.NoError(t, err)
+	conn.Close()
+}
+
+func TestDialContextExhausted(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	conn, err := DialContext(context.Background(), "tcp", addr, 2, time.Millisecond)
+	assert.Nil(t, conn)
+	assert.True(t, errors.Is(err, syscall.ECONNREFUSED))
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 3, rerr.Attempts)
+}
+
+func TestRetryableDialError(t *testing.T) {
+	t.Parallel()
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	noHost := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "nope.invalid", IsNotFound: true}}
+	dnsTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "i/o timeout", Name: "slow", IsTimeout: true}}
+
+	assert.True(t, retryableDialError(refused))
+	assert.True(t, retryableDialError(timeout))
+	assert.True(t, retryableDialError(dnsTimeout))
+	assert.False(t, retryableDialError(noHost))
+	assert.False(t, retryableDialError(errors.New("oops")))
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	t.Parallel()
+	retried := []error{
+		&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded},
+		os.ErrDeadlineExceeded,
+		&net.DNSError{Err: "i/o timeout", Name: "slow", IsTimeout: true},
+		io.ErrUnexpectedEOF,
+		fmt.Errorf("decode: %w", io.ErrUnexpectedEOF),
+		&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+	}
+	for _, err := range retried {
+		assert.True(t, DefaultRetryable(err), "err=%v", err)
+	}
+
+	notRetried := []error{
+		nil,
+		context.Canceled,
+		context.DeadlineExceeded,
+		fmt.Errorf("get: %w", context.DeadlineExceeded),
+		io.EOF,
+		&net.DNSError{Err: "no such host", Name: "nope.invalid", IsNotFound: true},
+		errors.New("oops"),
+	}
+	for _, err := range notRetried {
+		assert.False(t, DefaultRetryable(err), "err=%v", err)
+	}
+}
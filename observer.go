@@ -0,0 +1,35 @@
+package retry
+
+// Observer is notified of the attempts and outcomes of a Retrier, for
+// example to count them with the metrics library of a service,
+// without the package depending on it. The methods are called from
+// the goroutine of the retry loop, so they should be quick. A panic in
+// an Observer is recovered and ignored, so a misbehaving
+// implementation can't break the retry loop.
+type Observer interface {
+	// AttemptStarted is called before each call of f.
+	AttemptStarted()
+	// AttemptFailed is called with the error of each failed call of f.
+	AttemptFailed(err error)
+	// Succeeded is called once when an attempt succeeds, with the
+	// number of calls of f.
+	Succeeded(attempts int)
+	// GaveUp is called once when the retry loop gives up, with the
+	// number of calls of f.
+	GaveUp(attempts int)
+}
+
+// WithObserver notifies o of the attempts and outcomes of the Retrier.
+func WithObserver(o Observer) Option {
+	return func(r *Retrier) {
+		r.observer = o
+	}
+}
+
+// observe calls a method of an Observer, ignoring its panics.
+func observe(f func()) {
+	defer func() {
+		_ = recover()
+	}()
+	f()
+}
@@ -0,0 +1,82 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingObserver struct {
+	started, failed, succeeded, gaveUp int
+	panics                             bool
+}
+
+func (o *countingObserver) AttemptStarted() {
+	o.started++
+	if o.panics {
+		panic("oops")
+	}
+}
+
+func (o *countingObserver) AttemptFailed(error) {
+	o.failed++
+	if o.panics {
+		panic("oops")
+	}
+}
+
+func (o *countingObserver) Succeeded(attempts int) {
+	o.succeeded += attempts
+	if o.panics {
+		panic("oops")
+	}
+}
+
+func (o *countingObserver) GaveUp(attempts int) {
+	o.gaveUp += attempts
+	if o.panics {
+		panic("oops")
+	}
+}
+
+func TestWithObserver(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	o := &countingObserver{}
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0), WithObserver(o))
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		if n++; n < 2 {
+			return ErrOops
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &countingObserver{started: 2, failed: 1, succeeded: 2}, o)
+
+	err = r.Do(context.Background(), func(context.Context) error {
+		return ErrOops
+	})
+	assert.Error(t, err)
+	assert.Equal(t, &countingObserver{started: 5, failed: 4, succeeded: 2, gaveUp: 3}, o)
+}
+
+func TestWithObserverPanics(t *testing.T) {
+	t.Parallel()
+	o := &countingObserver{panics: true}
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0), WithObserver(o))
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		if n++; n < 3 {
+			return errors.New("oops")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, &countingObserver{started: 3, failed: 2, succeeded: 3, panics: true}, o)
+}
@@ -0,0 +1,68 @@
+package retry
+
+import (
+	"context"
+	"sync"
+)
+
+// gate holds the attempts of a paused Retrier.
+type gate struct {
+	mu     sync.Mutex
+	paused bool
+	// resumed is closed by Resume.
+	resumed chan struct{}
+}
+
+// Pause holds all new attempts of r, of every running and future
+// call of Do, until Resume is called, for example while a circuit
+// breaker is open during an incident. Attempts that are already
+// running are not interrupted. While paused, Do still returns as soon
+// as its context is done. Pause can only be used with a Retrier made
+// by New or Clone, and a clone can be paused on its own.
+func (r *Retrier) Pause() {
+	g := r.mustGate()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets the attempts that Pause held start again.
+func (r *Retrier) Resume() {
+	g := r.mustGate()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resumed)
+	}
+}
+
+func (r *Retrier) mustGate() *gate {
+	if r.gate == nil {
+		panic("retry: Pause and Resume need a Retrier made by New")
+	}
+	return r.gate
+}
+
+// wait until the gate is not paused, or ctx is done. A nil gate is
+// never paused.
+func (g *gate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	paused, resumed := g.paused, g.resumed
+	g.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
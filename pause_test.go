@@ -0,0 +1,66 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPause(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0))
+	r.Pause()
+	r.Pause()
+
+	var n int32
+	done := make(chan error)
+	go func() {
+		done <- r.Do(context.Background(), func(context.Context) error {
+			atomic.AddInt32(&n, 1)
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Do returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Zero(t, atomic.LoadInt32(&n))
+
+	r.Resume()
+	r.Resume()
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+}
+
+func TestPauseBetweenAttempts(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0))
+	var n int32
+	ctx, cancelFn := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
+	err := r.Do(ctx, func(context.Context) error {
+		// The attempt in flight completes, the next one is held.
+		atomic.AddInt32(&n, 1)
+		r.Pause()
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+
+	// A clone isn't paused.
+	assert.NoError(t, r.Clone().Do(context.Background(), func(context.Context) error {
+		return nil
+	}))
+	r.Resume()
+}
+
+func TestPauseNotNew(t *testing.T) {
+	t.Parallel()
+	assert.Panics(t, func() { (&Retrier{}).Pause() })
+}
@@ -0,0 +1,50 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PollUntil checks condition every interval until it returns true,
+// or ctx is done, like the wait package of Kubernetes. It sleeps
+// interval before each check, including the first one, see
+// PollImmediate to check right away. Unlike the retries of
+// XWithCondition, an error of condition stops the polling, and is
+// returned as is. When ctx is done, its error is returned wrapped
+// together with ErrConditionNotMet.
+//
+// Example 1:
+//    err := retry.PollUntil(ctx, time.Second, func(ctx context.Context) (bool, error) {
+//        pod, err := client.GetPod(ctx, name)
+//        if err != nil {
+//            return false, err
+//        }
+//        return pod.Ready, nil
+//    })
+func PollUntil(ctx context.Context, interval time.Duration, condition func(ctx context.Context) (bool, error)) error {
+	return poll(ctx, interval, interval, condition)
+}
+
+// PollImmediate is like PollUntil, but checks condition right away,
+// before the first sleep.
+func PollImmediate(ctx context.Context, interval time.Duration, condition func(ctx context.Context) (bool, error)) error {
+	return poll(ctx, 0, interval, condition)
+}
+
+func poll(ctx context.Context, delay, interval time.Duration, condition func(ctx context.Context) (bool, error)) error {
+	if interval <= 0 {
+		return errors.New("interval must be greater than 0")
+	}
+	r := &Retrier{retries: Infinite, strategy: Constant(interval), initialDelay: delay}
+	return r.Do(ctx, func(ctx context.Context) error {
+		done, err := condition(ctx)
+		switch {
+		case err != nil:
+			return Permanent(err)
+		case !done:
+			return ErrConditionNotMet
+		}
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollUntil(t *testing.T) {
+	t.Parallel()
+	n := 0
+	start := time.Now()
+	err := PollUntil(context.Background(), 10*time.Millisecond, func(context.Context) (bool, error) {
+		n++
+		return n == 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	// It sleeps before every check, the first one too.
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
+
+func TestPollImmediate(t *testing.T) {
+	t.Parallel()
+	n := 0
+	start := time.Now()
+	err := PollImmediate(context.Background(), time.Minute, func(context.Context) (bool, error) {
+		n++
+		return true, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestPollError(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	err := PollImmediate(context.Background(), time.Millisecond, func(context.Context) (bool, error) {
+		n++
+		return false, ErrOops
+	})
+	assert.Equal(t, ErrOops, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestPollCancelled(t *testing.T) {
+	t.Parallel()
+	ctx, cancelFn := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
+	err := PollImmediate(ctx, time.Millisecond, func(context.Context) (bool, error) {
+		return false, nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, errors.Is(err, ErrConditionNotMet))
+
+	assert.Error(t, PollUntil(context.Background(), 0, func(context.Context) (bool, error) {
+		return true, nil
+	}))
+}
@@ -0,0 +1,78 @@
+package retry
+
+import (
+	"hash/fnv"
+	"math/rand"
+	randv2 "math/rand/v2"
+	"sync"
+)
+
+// source of the random jitter added to the backoff.
+type source interface {
+	Int63n(n int64) int64
+}
+
+// lockedSource makes a *rand.Rand safe for concurrent use.
+type lockedSource struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *lockedSource) Int63n(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Int63n(n)
+}
+
+// globalSource draws from the auto-seeded generator of math/rand/v2,
+// which is safe for concurrent use without a global lock.
+type globalSource struct{}
+
+func (globalSource) Int63n(n int64) int64 {
+	return randv2.Int64N(n)
+}
+
+// defaultSource is used when no *rand.Rand is configured, so the
+// jitter doesn't depend on the state of the process-global source
+// of math/rand.
+var defaultSource source = globalSource{}
+
+// WithRand sets the random number generator of the jitter, for
+// example one with a fixed seed so tests can assert on exact
+// backoffs. The Retrier guards rnd with a lock, so it stays safe
+// for concurrent use, but rnd must not be used elsewhere. A nil rnd
+// restores the default source.
+func WithRand(rnd *rand.Rand) Option {
+	return func(r *Retrier) {
+		if rnd == nil {
+			r.rnd = nil
+			return
+		}
+		r.rnd = &lockedSource{rnd: rnd}
+	}
+}
+
+// source of the randomness of r, other than the jitter.
+func (r *Retrier) source() source {
+	if r.rnd == nil {
+		return defaultSource
+	}
+	return r.rnd
+}
+
+// keyedSource is the jitter of DoKeyed, seeded from the hash of a key.
+// It is only used by one retry loop, so it has no lock.
+type keyedSource struct {
+	rnd *randv2.Rand
+}
+
+func newKeyedSource(key string) keyedSource {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	seed := h.Sum64()
+	return keyedSource{rnd: randv2.New(randv2.NewPCG(seed, seed))}
+}
+
+func (s keyedSource) Int63n(n int64) int64 {
+	return s.rnd.Int64N(n)
+}
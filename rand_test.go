@@ -0,0 +1,117 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRand(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+
+	schedule := func() []time.Duration {
+		clock := newFakeClock()
+		r := New(
+			WithMaxAttempts(4),
+			WithMaxBackoff(max),
+			WithClock(clock),
+			WithRand(rand.New(rand.NewSource(42))),
+		)
+		_ = r.Do(context.Background(), func(context.Context) error {
+			return errors.New("oops")
+		})
+		return clock.Sleeps()
+	}
+
+	// With a fixed seed the jittered backoffs are exact.
+	rnd := rand.New(rand.NewSource(42))
+	min := max / 8
+	want := []time.Duration{
+		0,
+		min<<1 + time.Duration(rnd.Int63n(int64(min)*1)),
+		min<<2 + time.Duration(rnd.Int63n(int64(min)*2)),
+		max,
+	}
+	got := schedule()
+	assert.Equal(t, want, got[:len(want)])
+
+	// And the same every time.
+	assert.Equal(t, got, schedule())
+
+	// A nil rnd is the default source.
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithRand(nil))
+	err := r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+}
+
+func TestDefaultSourceDistribution(t *testing.T) {
+	t.Parallel()
+	const (
+		n       = 10
+		draws   = 100000
+		perSlot = draws / n
+	)
+	var counts [n]int
+	for i := 0; i < draws; i++ {
+		v := defaultSource.Int63n(n)
+		if v < 0 || v >= n {
+			t.Fatalf("Int63n(%d) = %d, out of range", n, v)
+		}
+		counts[v]++
+	}
+
+	// Chi-squared with 9 degrees of freedom, a uniform source stays
+	// below 33.72 with a probability of 99.99%.
+	var chi2 float64
+	for _, c := range counts {
+		d := float64(c - perSlot)
+		chi2 += d * d / perSlot
+	}
+	assert.Less(t, chi2, 33.72, "counts: %v", counts)
+}
+
+func TestDefaultJitterDistribution(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	e := exponential{max: max}
+
+	// The proportional jitter of try 1 is [0, min), so the mean of the
+	// backoff is min*2 + min/2.
+	min := max / 8
+	const draws = 20000
+	var sum time.Duration
+	for i := 0; i < draws; i++ {
+		d := e.Backoff(1)
+		assert.True(t, d >= min*2 && d < min*3, "backoff %v", d)
+		sum += d
+	}
+	mean := sum / draws
+	want := min*2 + min/2
+	assert.InDelta(t, float64(want), float64(mean), float64(min/50))
+}
+
+func TestDoKeyed(t *testing.T) {
+	t.Parallel()
+	schedule := func(key string) []time.Duration {
+		clock := newFakeClock()
+		r := New(WithMaxAttempts(5), WithMaxBackoff(8*time.Second), WithJitterFactor(0.5), WithClock(clock))
+		_ = r.DoKeyed(context.Background(), key, func(context.Context) error {
+			return errors.New("oops")
+		})
+		return clock.Sleeps()
+	}
+
+	// The same key backs off the same, every time.
+	a := schedule("user:1")
+	assert.Equal(t, a, schedule("user:1"))
+	// Other keys are spread out.
+	assert.NotEqual(t, a, schedule("user:2"))
+	assert.NotEqual(t, a, schedule(""))
+}
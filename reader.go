@@ -0,0 +1,56 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// RetryReader returns a Reader whose Read retries the Read of r, up
+// to x times with the usual backoff, when it fails with an error for
+// which retryable returns true, or any error for a nil retryable.
+// Reads of data and io.EOF are passed through unchanged, and so is a
+// failed Read that returned some data, so the next Read retries it.
+// If all the attempts of a Read fail, it returns a *RetryError wrapped
+// around the last error.
+//
+// This only makes sense for a source whose Read can be retried after
+// an error without losing or repeating data, like a reader that
+// reconnects to a stream at its current offset.
+//
+// Example 1:
+//    r := retry.RetryReader(stream, 3, time.Second, IsTemporary)
+//    _, err := io.Copy(dst, r)
+func RetryReader(r io.Reader, x int, maxBackoff time.Duration, retryable func(error) bool) io.Reader {
+	return &retryReader{
+		r:       r,
+		retrier: &Retrier{retries: x, maxBackoff: maxBackoff, retryable: retryable},
+	}
+}
+
+type retryReader struct {
+	r       io.Reader
+	retrier *Retrier
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	var (
+		n       int
+		readErr error
+	)
+	err := rr.retrier.Do(context.Background(), func(context.Context) error {
+		n, readErr = rr.r.Read(p)
+		if n > 0 || readErr == io.EOF {
+			return nil
+		}
+		return readErr
+	})
+	if err != nil {
+		return n, err
+	}
+	if n > 0 && readErr != nil && readErr != io.EOF {
+		// keep the data, the next Read retries the error
+		return n, nil
+	}
+	return n, readErr
+}
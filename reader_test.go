@@ -0,0 +1,89 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyReader fails fails times before every read of data.
+type flakyReader struct {
+	r     io.Reader
+	fails int
+	err   error
+	n     int
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if f.n++; f.n <= f.fails {
+		return 0, f.err
+	}
+	f.n = 0
+	return f.r.Read(p)
+}
+
+func TestRetryReader(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	flaky := &flakyReader{r: strings.NewReader("hello"), fails: 2, err: ErrOops}
+	b, err := io.ReadAll(RetryReader(flaky, 2, 0, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestRetryReaderExhausted(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	flaky := &flakyReader{r: strings.NewReader("hello"), fails: 3, err: ErrOops}
+	_, err := io.ReadAll(RetryReader(flaky, 2, 0, nil))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+}
+
+func TestRetryReaderNotRetryable(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	flaky := &flakyReader{r: strings.NewReader("hello"), fails: 1, err: ErrOops}
+	r := RetryReader(flaky, 2, 0, func(err error) bool {
+		return false
+	})
+	_, err := r.Read(make([]byte, 8))
+	assert.Equal(t, ErrOops, err)
+	assert.Equal(t, 1, flaky.n)
+}
+
+func TestRetryReaderPartial(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	r := RetryReader(&partialReader{err: ErrOops}, 2, 0, nil)
+	p := make([]byte, 8)
+	n, err := r.Read(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "he", string(p[:n]))
+	n, err = r.Read(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "llo", string(p[:n]))
+	_, err = r.Read(p)
+	assert.Equal(t, io.EOF, err)
+}
+
+// partialReader returns some data with an error, then the rest.
+type partialReader struct {
+	err error
+	n   int
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	switch r.n++; r.n {
+	case 1:
+		return copy(p, "he"), r.err
+	case 2:
+		return 0, r.err
+	case 3:
+		return copy(p, "llo"), nil
+	}
+	return 0, io.EOF
+}
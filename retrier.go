@@ -0,0 +1,1074 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/lytics/retry/internal/httpretry"
+)
+
+const (
+	// defaultMaxAttempts and defaultMaxBackoff are used by New
+	// when not set by an option, the same as:
+	//    retry.X(3, 5*time.Second, f)
+	defaultMaxAttempts = 4
+	defaultMaxBackoff  = 5 * time.Second
+)
+
+// Retrier is a retry policy that is configured once and can be used
+// for any number of retry loops. Define one for a service and reuse
+// it across packages, rather than passing the number of retries and
+// the max backoff at every call site. A Retrier is safe for concurrent
+// use, unless it is configured with a stateful BackoffStrategy.
+//
+// Example 1:
+//    r := retry.New(retry.WithMaxAttempts(6), retry.WithMaxBackoff(5*time.Second))
+//
+//    err := r.Do(ctx, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+type Retrier struct {
+	// retries is the x of XWithContext, the number of
+	// attempts after the first one.
+	retries int
+	// minRandomAttempts and maxRandomAttempts are the
+	// range of a random number of attempts, replacing
+	// retries, zero means not random.
+	minRandomAttempts, maxRandomAttempts int
+	// maxConsecutive is the number of failures in a row,
+	// without progress, to give up after, zero means
+	// no limit.
+	maxConsecutive int
+	maxBackoff     time.Duration
+	// maxElapsed is the time budget for all attempts,
+	// zero means no budget.
+	maxElapsed time.Duration
+	// maxTotalBackoff is the budget of the sum of the
+	// backoffs, zero means no budget. When exceeded,
+	// stopOnTotalBackoff gives up instead of retrying
+	// without a backoff.
+	maxTotalBackoff    time.Duration
+	stopOnTotalBackoff bool
+	// retryable reports if an error should be retried,
+	// nil retries all errors.
+	retryable func(error) bool
+	// retryOn and abortOn are the sentinels to retry
+	// only, and never, nil means any.
+	retryOn, abortOn []error
+	// onRetry is called before sleeping for another attempt.
+	onRetry func(attempt int, err error, nextBackoff time.Duration)
+	// onGiveUp and onSuccess are called once when the
+	// loop ends, nil means no hook.
+	onGiveUp  func(attempts int, elapsed time.Duration, err error)
+	onSuccess func(attempts int, elapsed time.Duration)
+	// logger logs the retries, nil means no logging.
+	logger Logger
+	// observer is notified of the attempts, nil means
+	// no observer.
+	observer Observer
+	// clock is the source of time, nil means the system clock.
+	clock Clock
+	// rnd is the source of the jitter, nil means the
+	// package's own source.
+	rnd source
+	// ramp is the number of tries to reach maxBackoff,
+	// zero means defaultRamp.
+	ramp int
+	// minBackoff is the backoff of the first retry, zero
+	// means it is derived from the ramp.
+	minBackoff time.Duration
+	// multiplier is the growth of the backoff per
+	// attempt, zero means doubling.
+	multiplier float64
+	// until is the time after which no new attempts
+	// are started, zero means no such deadline.
+	until time.Time
+	// budget limits the retries, nil means no limit.
+	budget *Budget
+	// initialDelay is waited before the first attempt.
+	initialDelay time.Duration
+	// startupSpread is the range of a random delay
+	// before the first attempt.
+	startupSpread time.Duration
+	// spinThreshold is the backoff below which the loop
+	// spins instead of arming a timer, zero means never.
+	spinThreshold time.Duration
+	// subtractExecution shortens each backoff by the
+	// duration of the attempt before it.
+	subtractExecution bool
+	// jitter is the strategy for the random part
+	// of the backoff.
+	jitter Jitter
+	// cap is the ceiling of the jitter, zero means
+	// maxBackoff.
+	cap time.Duration
+	// jitterFactor is the fraction of WithJitterFactor.
+	jitterFactor float64
+	// maxJitter limits the jitter, zero means no limit.
+	maxJitter time.Duration
+	// jitterAtMax only jitters once the ramp reached the
+	// max backoff.
+	jitterAtMax bool
+	// strategy replaces the exponential backoff when set.
+	strategy BackoffStrategy
+	// hardCancel returns from a running attempt as
+	// soon as the context is done.
+	hardCancel bool
+	// abort stops the retries like the context of Do,
+	// nil means it isn't set.
+	abort context.Context
+	// attemptTimeout returns the bound of each attempt,
+	// nil or zero means the attempt isn't bounded.
+	attemptTimeout func(attempt int) time.Duration
+	// joinErrors returns the errors of all attempts
+	// instead of the latest one.
+	joinErrors bool
+	// recover converts panics of f into errors.
+	recover bool
+	// validator is the func(T) error that checks the result
+	// of a successful call of f, nil means all results are
+	// valid.
+	validator any
+	// name of the operation of DoNamed, empty for Do.
+	name string
+	// gate holds the attempts while paused, nil means
+	// it can't be paused.
+	gate *gate
+	// err is set by an invalid option, and returned by
+	// every retry loop.
+	err error
+}
+
+// Option configures a Retrier.
+type Option func(*Retrier)
+
+// New Retrier configured by opts. Without options it makes up to
+// 4 attempts, with a backoff that reaches 5 seconds within three
+// attempts. Invalid options are reported by the Retrier's methods.
+func New(opts ...Option) *Retrier {
+	r := &Retrier{
+		retries:    defaultMaxAttempts - 1,
+		maxBackoff: defaultMaxBackoff,
+		gate:       &gate{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Clone returns a copy of r with opts applied on top of its policy,
+// for example more attempts for one critical call, without changing
+// r. The copy shares the values that r was configured with by
+// reference, like the *rand.Rand of WithRand, the BackoffStrategy of
+// WithBackoff, the Budget and the hooks, so a stateful strategy is
+// shared with r and needs a WithBackoff of its own in opts. The copy
+// isn't paused along with r, see Pause.
+//
+// Example 1:
+//    critical := r.Clone(retry.WithMaxAttempts(10))
+func (r *Retrier) Clone(opts ...Option) *Retrier {
+	c := *r
+	c.gate = &gate{}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// setErr keeps the first error of an invalid option.
+func (r *Retrier) setErr(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// WithMaxAttempts sets the maximum number of calls of f, including
+// the first one. It is x+1 of XWithContext, so n cannot be less than 1.
+// Infinite retries until f succeeds or the context is done.
+func WithMaxAttempts(n int) Option {
+	return func(r *Retrier) {
+		if n < 1 {
+			r.setErr(errors.New("max attempts cannot be less than 1"))
+			return
+		}
+		r.minRandomAttempts, r.maxRandomAttempts = 0, 0
+		if n == Infinite {
+			r.retries = Infinite
+			return
+		}
+		r.retries = n - 1
+	}
+}
+
+// ErrMaxConsecutiveFailures is returned, wrapped together with the
+// last error of f, when the attempts failed too many times in a row,
+// see WithMaxConsecutiveFailures.
+var ErrMaxConsecutiveFailures = errors.New("too many consecutive failures")
+
+// WithMaxConsecutiveFailures gives up after n attempts in a row that
+// failed without progress, for a long-running loop, like a connection
+// loop, that should ride out the occasional blip, but not a sustained
+// outage. An attempt that returns Progress resets the count. It is
+// independent of WithMaxAttempts, usually Infinite for such a loop,
+// and whichever runs out first stops the retries. The loop then
+// returns the last error of f wrapped with ErrMaxConsecutiveFailures.
+// n cannot be less than 1.
+//
+// Example 1:
+//    r := retry.New(retry.WithMaxAttempts(retry.Infinite), retry.WithMaxConsecutiveFailures(5))
+//    err := r.Do(ctx, func(ctx context.Context) error {
+//        n, err := Consume(ctx)
+//        if n > 0 {
+//            return retry.Progress(err)
+//        }
+//        return err
+//    })
+func WithMaxConsecutiveFailures(n int) Option {
+	return func(r *Retrier) {
+		if n < 1 {
+			r.setErr(errors.New("max consecutive failures cannot be less than 1"))
+			return
+		}
+		r.maxConsecutive = n
+	}
+}
+
+// WithRandomAttempts picks a random number of attempts in [min, max]
+// for each call of Do, instead of a fixed one, so a fleet of clients
+// don't all give up at the same time. The number is drawn from the
+// source of WithRand, so a fixed seed makes it reproducible in tests.
+// It replaces WithMaxAttempts, and min cannot be less than 1, or
+// greater than max.
+func WithRandomAttempts(min, max int) Option {
+	return func(r *Retrier) {
+		if min < 1 || min > max || max == Infinite {
+			r.setErr(errors.New("random attempts must be between 1 and max"))
+			return
+		}
+		r.minRandomAttempts, r.maxRandomAttempts = min, max
+	}
+}
+
+// WithMaxBackoff sets the maximum backoff between attempts, which
+// is reached within three attempts unless set by WithRampAttempts.
+func WithMaxBackoff(d time.Duration) Option {
+	return func(r *Retrier) {
+		r.maxBackoff = d
+	}
+}
+
+// WithCap sets a hard ceiling of the backoff above the max backoff,
+// which then is only the soft target of the ramp. A jitter that adds
+// to the backoff, like WithJitterFactor, can then push it above the
+// max, up to hard, which spreads the retries beyond the max. The
+// default proportional jitter still stops at the max once the ramp
+// reaches it. By default the cap is the max backoff. It is an error
+// when hard is less than the max backoff.
+//
+// Example 1:
+//    // Ramp to 5s, but jitter by ±50% up to 7.5s.
+//    r := retry.New(
+//        retry.WithMaxBackoff(5*time.Second),
+//        retry.WithCap(7500*time.Millisecond),
+//        retry.WithJitterFactor(0.5),
+//    )
+func WithCap(hard time.Duration) Option {
+	return func(r *Retrier) {
+		r.cap = hard
+	}
+}
+
+// WithRampAttempts sets the number of attempts for the backoff to
+// reach the max backoff, instead of three. The backoff starts at
+// max/2^n and doubles every attempt, so a longer ramp puts a more
+// gradual pressure on a flaky downstream. The ramp cannot be longer
+// than 62 attempts, as 2^63 overflows a time.Duration.
+func WithRampAttempts(n int) Option {
+	return func(r *Retrier) {
+		if n < 1 || n > 62 {
+			r.setErr(errors.New("ramp attempts must be between 1 and 62"))
+			return
+		}
+		r.ramp = n
+	}
+}
+
+// WithMinBackoff sets the backoff of the first retry, instead of
+// deriving it from the max backoff, for example to start at 50ms and
+// cap at 10s. The backoff still doubles every attempt, so the ramp
+// takes as many attempts as it needs to reach the max backoff, about
+// log2(max/min)+1, and WithRampAttempts is ignored. It is an error
+// when min is greater than the max backoff.
+func WithMinBackoff(min time.Duration) Option {
+	return func(r *Retrier) {
+		if min <= 0 {
+			r.setErr(errors.New("min backoff must be greater than 0"))
+			return
+		}
+		r.minBackoff = min
+	}
+}
+
+// WithMultiplier sets the growth of the backoff per attempt, instead
+// of doubling, for example 1.5 for a gentler or 3 for a steeper curve.
+// The ramp still reaches the max backoff after the same number of
+// attempts, so the backoff starts at max/m^n, where n is the ramp of
+// WithRampAttempts. Combined with WithMinBackoff, the backoff starts
+// at min and grows by m until it reaches the max. Each backoff is
+// computed from m^n for its own attempt, not from the previous one, so
+// it stays within a nanosecond of the exact curve however long the
+// loop runs, instead of drifting. The multiplier must be greater
+// than 1.
+func WithMultiplier(m float64) Option {
+	return func(r *Retrier) {
+		if !(m > 1) || math.IsInf(m, 1) {
+			r.setErr(errors.New("multiplier must be greater than 1"))
+			return
+		}
+		r.multiplier = m
+	}
+}
+
+// WithInitialDelay waits d before the first attempt, instead of
+// starting it immediately, for example to give a resource that was
+// just created a moment to become ready. The wait can be cancelled
+// with the context, like the backoffs.
+func WithInitialDelay(d time.Duration) Option {
+	return func(r *Retrier) {
+		if d < 0 {
+			r.setErr(errors.New("initial delay cannot be less than 0"))
+			return
+		}
+		r.initialDelay = d
+	}
+}
+
+// WithStartupSpread waits a random [0, spread) before the first
+// attempt, so a fleet of processes that start at the same time, and
+// retry the same dependency, don't all hit it at the same instant.
+// Unlike the jitter of the backoffs, it only spreads the first
+// attempt. It adds to the delay of WithInitialDelay, and can be
+// cancelled with the context.
+func WithStartupSpread(spread time.Duration) Option {
+	return func(r *Retrier) {
+		if spread < 0 {
+			r.setErr(errors.New("startup spread cannot be less than 0"))
+			return
+		}
+		r.startupSpread = spread
+	}
+}
+
+// WithSpinThreshold makes the retry loop busy-wait for the backoffs
+// shorter than d, yielding with runtime.Gosched, instead of arming a
+// timer, whose resolution is too coarse for a tight loop, like the
+// retries of a contended lock or a compare-and-swap, where a timer
+// can oversleep a backoff of microseconds many times over. It burns a
+// CPU for the whole backoff, so keep d small, in the microseconds,
+// and only use it where the latency matters more than the CPU. It
+// only applies to the system clock, a Clock of WithClock waits as
+// usual. Zero disables it, which is the default.
+//
+// Example 1:
+//    r := retry.New(retry.WithBackoff(retry.Constant(5*time.Microsecond)), retry.WithSpinThreshold(50*time.Microsecond))
+func WithSpinThreshold(d time.Duration) Option {
+	return func(r *Retrier) {
+		if d < 0 {
+			r.setErr(errors.New("spin threshold cannot be less than 0"))
+			return
+		}
+		r.spinThreshold = d
+	}
+}
+
+// WithSubtractExecutionTime shortens each backoff by the time the
+// failed attempt before it took, down to no backoff at all, so the
+// attempts start a backoff apart, instead of the backoff plus the
+// latency of f. For example, an attempt that took 300ms before a 1s
+// backoff is followed by a sleep of 700ms. The backoff of RetryAfter
+// is a wait the server asked for, and isn't shortened.
+func WithSubtractExecutionTime() Option {
+	return func(r *Retrier) {
+		r.subtractExecution = true
+	}
+}
+
+// WithMaxElapsedTime sets a time budget for all attempts, see
+// XWithDeadline.
+func WithMaxElapsedTime(d time.Duration) Option {
+	return func(r *Retrier) {
+		if d <= 0 {
+			r.setErr(errors.New("max elapsed time must be greater than 0"))
+			return
+		}
+		r.maxElapsed = d
+	}
+}
+
+// WithMaxTotalBackoff sets a budget of the sum of the backoffs, to
+// bound the latency of a call with many attempts. Unlike
+// WithMaxElapsedTime, it only counts the sleeps, not the time f takes.
+// Once the sleeps add up to d, the remaining attempts run right away,
+// without a backoff, see WithMaxTotalBackoffStop to give up instead.
+func WithMaxTotalBackoff(d time.Duration) Option {
+	return func(r *Retrier) {
+		if d <= 0 {
+			r.setErr(errors.New("max total backoff must be greater than 0"))
+			return
+		}
+		r.maxTotalBackoff = d
+		r.stopOnTotalBackoff = false
+	}
+}
+
+// WithMaxTotalBackoffStop is like WithMaxTotalBackoff, but gives up
+// when the next backoff would exceed d, and returns the last error of
+// f wrapped with ErrBudgetExhausted.
+func WithMaxTotalBackoffStop(d time.Duration) Option {
+	return func(r *Retrier) {
+		WithMaxTotalBackoff(d)(r)
+		r.stopOnTotalBackoff = true
+	}
+}
+
+// WithRetryable sets the predicate of the errors to retry, see
+// XWithPredicate.
+func WithRetryable(retryable func(error) bool) Option {
+	return func(r *Retrier) {
+		r.retryable = retryable
+	}
+}
+
+// WithRetryOn only retries the errors that match one of errs with
+// errors.Is, and returns any other error right away, a simpler
+// alternative to WithRetryable for sentinel errors. Without errs,
+// every error is retried again.
+//
+// Example 1:
+//    r := retry.New(retry.WithRetryOn(ErrUnavailable, io.ErrUnexpectedEOF))
+func WithRetryOn(errs ...error) Option {
+	return func(r *Retrier) {
+		r.retryOn = append([]error(nil), errs...)
+	}
+}
+
+// WithAbortOn returns the errors that match one of errs with errors.Is
+// right away, and retries any other error. When an error matches both
+// WithAbortOn and WithRetryOn, the abort wins.
+func WithAbortOn(errs ...error) Option {
+	return func(r *Retrier) {
+		r.abortOn = append([]error(nil), errs...)
+	}
+}
+
+// isAny reports if err matches one of errs with errors.Is.
+func isAny(err error, errs []error) bool {
+	for _, target := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithOnRetry sets a hook called after each failed attempt that will
+// be followed by another one, see XWithContextHook.
+func WithOnRetry(onRetry func(attempt int, err error, nextBackoff time.Duration)) Option {
+	return func(r *Retrier) {
+		r.onRetry = onRetry
+	}
+}
+
+// WithOnGiveUp sets a hook called once when the retry loop gives up,
+// with the number of calls of f, the time since the start of the
+// call, including both the attempts and the backoffs, and the error
+// the loop returns, for example to emit a single metric per operation
+// rather than per attempt. It is called for every failure, including a
+// cancelled context or a Permanent error, but not for an invalid
+// configuration.
+func WithOnGiveUp(onGiveUp func(attempts int, elapsed time.Duration, err error)) Option {
+	return func(r *Retrier) {
+		r.onGiveUp = onGiveUp
+	}
+}
+
+// WithOnSuccess sets a hook called once when an attempt succeeds,
+// with the number of calls of f it took, and the time since the start
+// of the call, for example to report "took 4.2s across 3 attempts".
+func WithOnSuccess(onSuccess func(attempts int, elapsed time.Duration)) Option {
+	return func(r *Retrier) {
+		r.onSuccess = onSuccess
+	}
+}
+
+// WithHardCancel makes the retry loop return the context's error as
+// soon as the context is done, even when f is still running and
+// doesn't cancel, for example a stuck call without a deadline. Each
+// attempt runs f in a goroutine, and the goroutine of an abandoned
+// attempt keeps running until f returns, so f must still honor its
+// context to not leak. A panic of f is raised again in the goroutine
+// of the caller, as without the hard cancel, unless the attempt was
+// abandoned, then it is dropped.
+func WithHardCancel() Option {
+	return func(r *Retrier) {
+		r.hardCancel = true
+	}
+}
+
+// WithAbortContext stops the retries of every call when abort is
+// done, as if the context of the call was cancelled with the cause of
+// abort, for example a context that is cancelled on shutdown, shared
+// by all the retry loops of a server. It saves merging abort into the
+// context of each call. The context of f is done too, but an attempt
+// that doesn't honor it is allowed to complete first, unless
+// WithHardCancel is set.
+//
+// Example 1:
+//    r := retry.New(retry.WithAbortContext(shutdownCtx))
+//    err := r.Do(reqCtx, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+func WithAbortContext(abort context.Context) Option {
+	return func(r *Retrier) {
+		r.abort = abort
+	}
+}
+
+// WithPerAttemptTimeout bounds each call of f to d, through the
+// deadline of a child context of the retry loop's context. An attempt
+// that times out is a failure like any other, and is retried while
+// attempts remain. When all attempts fail, the error is f's last
+// error, which for a timed out attempt is usually the deadline error.
+func WithPerAttemptTimeout(d time.Duration) Option {
+	return func(r *Retrier) {
+		if d <= 0 {
+			r.setErr(errors.New("per attempt timeout must be greater than 0"))
+			return
+		}
+		r.attemptTimeout = func(int) time.Duration { return d }
+	}
+}
+
+// WithPerAttemptTimeoutFunc is like WithPerAttemptTimeout, but the
+// timeout can vary by attempt, starting at 1, for example a short one
+// first, to fail fast, and longer ones for a server that is slow to
+// recover. A timeout of zero or less leaves that attempt unbounded,
+// and a nil timeout removes the per-attempt timeout.
+//
+// Example 1:
+//    // 1s, 2s, 4s, ...
+//    r := retry.New(retry.WithPerAttemptTimeoutFunc(func(attempt int) time.Duration {
+//        return time.Second << (attempt - 1)
+//    }))
+func WithPerAttemptTimeoutFunc(timeout func(attempt int) time.Duration) Option {
+	return func(r *Retrier) {
+		r.attemptTimeout = timeout
+	}
+}
+
+// WithJoinErrors makes a retry loop that gives up return the errors
+// of all attempts joined with errors.Join, instead of the last one,
+// also when a Permanent or non-retryable error stops it early.
+// errors.Is then matches any of them, and the message lists them all,
+// which helps to debug attempts that failed for different reasons.
+func WithJoinErrors() Option {
+	return func(r *Retrier) {
+		r.joinErrors = true
+	}
+}
+
+// WithRecover makes the retry loop recover from panics in f. A
+// panic is converted into a *PanicError, with the panic value and
+// the stack trace, and is retried like any other failed attempt. If
+// all attempts panic, the last *PanicError is returned.
+func WithRecover() Option {
+	return func(r *Retrier) {
+		r.recover = true
+	}
+}
+
+// WithResultValidator checks the result of every call of f that
+// returned no error, and an error of valid turns the call into a
+// failed attempt, which is retried like an error of f, for example an
+// empty body or a truncated JSON document. It keeps the validation
+// out of f, and reusable across calls. It only applies to DoValue
+// with a result of exactly type T: any other retry loop of the
+// Retrier, including Do, fails before the first attempt.
+//
+// Example 1:
+//    r := retry.New(retry.WithResultValidator(func(body []byte) error {
+//        if len(body) == 0 {
+//            return errors.New("empty body")
+//        }
+//        return nil
+//    }))
+//    body, err := retry.DoValue(ctx, r, Fetch)
+func WithResultValidator[T any](valid func(T) error) Option {
+	return func(r *Retrier) {
+		r.validator = valid
+	}
+}
+
+// validating returns f with the results checked by valid.
+func validating[T any](f func(ctx context.Context) (T, error), valid func(T) error) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		v, err := f(ctx)
+		if err == nil {
+			err = valid(v)
+		}
+		return v, err
+	}
+}
+
+// Do runs function f until f returns nil or the attempts configured
+// for r run out, with the semantics of XWithContext.
+func (r *Retrier) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	_, _, err := run(ctx, r, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, f(ctx)
+	})
+	return err
+}
+
+// DoKeyed is like Do, but seeds the jitter from key, so every call
+// for the same key, in any process, backs off by exactly the same
+// schedule, while different keys are spread out, for example to keep
+// a cache stampede on one key reproducible. The seed is the 64-bit
+// FNV-1a hash of key, and it replaces the source of WithRand for the
+// call.
+//
+// Example 1:
+//    err := r.DoKeyed(ctx, key, func(ctx context.Context) error {
+//        return Refresh(ctx, key)
+//    })
+func (r *Retrier) DoKeyed(ctx context.Context, key string, f func(ctx context.Context) error) error {
+	c := *r
+	c.rnd = newKeyedSource(key)
+	return c.Do(ctx, f)
+}
+
+// DoNamed is like Do, but tags the retries with the name of the
+// operation, for the logs and traces of a Retrier that is shared by
+// many operations. The name is in the message of the Logger, in the
+// *RetryError, whose message becomes `operation "fetch-user" failed
+// after 3 attempts: ...`, and in the context of f, see
+// NameFromContext. Every error of a named call is such a *RetryError,
+// including those of a Permanent or non-retryable error, and the ones
+// passed to the OnRetry and OnGiveUp hooks, so errors.As finds the
+// name.
+//
+// Example 1:
+//    err := r.DoNamed(ctx, "fetch-user", func(ctx context.Context) error {
+//        return FetchUser(ctx, id)
+//    })
+func (r *Retrier) DoNamed(ctx context.Context, name string, f func(ctx context.Context) error) error {
+	c := *r
+	c.name = name
+	return c.Do(withName(ctx, name), f)
+}
+
+// DoValue runs function f until f returns a nil error or the attempts
+// configured for r run out, and returns the value of the successful
+// call, with the semantics of Do.
+//
+// Example 1:
+//    body, err := retry.DoValue(ctx, r, func(ctx context.Context) ([]byte, error) {
+//        return Fetch(ctx, url)
+//    })
+func DoValue[T any](ctx context.Context, r *Retrier, f func(ctx context.Context) (T, error)) (T, error) {
+	v, _, err := run(ctx, r, f)
+	return v, err
+}
+
+// backoffErr before try number try, after the failed attempt with
+// err, see ErrorBackoffStrategy.
+func (r *Retrier) backoffErr(try int, err error) time.Duration {
+	if s, ok := r.strategy.(ErrorBackoffStrategy); ok {
+		return s.BackoffErr(try, err)
+	}
+	return r.backoff(try)
+}
+
+// backoff before try number try, which starts at 0.
+func (r *Retrier) backoff(try int) time.Duration {
+	if s, ok := r.strategy.(randBackoff); ok {
+		return s.backoffRand(try, r.source())
+	}
+	if r.strategy != nil {
+		return r.strategy.Backoff(try)
+	}
+	return exponential{max: r.maxBackoff, min: r.minBackoff, ramp: r.ramp, multiplier: r.multiplier, jitter: r.jitter, cap: r.cap, factor: r.jitterFactor, maxJitter: r.maxJitter, atMaxOnly: r.jitterAtMax, rnd: r.rnd}.Backoff(try)
+}
+
+// run is the retry loop shared by the exported functions. It
+// returns the number of calls of f too.
+func run[T any](ctx context.Context, r *Retrier, f func(ctx context.Context) (T, error)) (T, int, error) {
+	if err := r.validate(); err != nil {
+		var zero T
+		return zero, 0, err
+	}
+	if _, ok := r.validator.(func(T) error); r.validator != nil && !ok {
+		var zero T
+		return zero, 0, fmt.Errorf("result validator %T doesn't match the results of type %v", r.validator, reflect.TypeFor[T]())
+	}
+	if onRetry := httpretry.OnRetry(ctx, r); onRetry != nil {
+		// the hook of a request of retryhttp, which keeps
+		// the gate of r
+		c := *r
+		c.onRetry = onRetry
+		r = &c
+	}
+	if r.maxRandomAttempts > 0 {
+		// a policy of its own for this call
+		c := *r
+		c.retries = r.minRandomAttempts - 1 + int(r.source().Int63n(int64(r.maxRandomAttempts-r.minRandomAttempts+1)))
+		r = &c
+	}
+	if r.abort != nil {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		abort := r.abort
+		if abort.Err() != nil {
+			// already done, before the first attempt
+			cancel(context.Cause(abort))
+		}
+		stop := context.AfterFunc(abort, func() { cancel(context.Cause(abort)) })
+		defer stop()
+	}
+	clock := r.getClock()
+	start := clock.Now()
+	v, attempts, err := loop(ctx, r, f)
+	if o := r.observer; o != nil {
+		if err != nil {
+			observe(func() { o.GaveUp(attempts) })
+		} else {
+			observe(func() { o.Succeeded(attempts) })
+		}
+	}
+	switch {
+	case err != nil && r.onGiveUp != nil:
+		r.onGiveUp(attempts, clock.Now().Sub(start), err)
+	case err == nil && r.onSuccess != nil:
+		r.onSuccess(attempts, clock.Now().Sub(start))
+	}
+	return v, attempts, err
+}
+
+// validate the configuration before the first attempt.
+func (r *Retrier) validate() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.retries < 0 {
+		return errors.New("x cannot be less than 0")
+	}
+	if r.maxBackoff < 0 {
+		return errors.New("maxBackoff cannot be less than 0")
+	}
+	if r.strategy == nil && r.minBackoff > r.maxBackoff {
+		return errors.New("min backoff cannot be greater than max backoff")
+	}
+	if r.cap != 0 && r.cap < r.maxBackoff {
+		return errors.New("cap cannot be less than max backoff")
+	}
+	return nil
+}
+
+// loop of the attempts of a valid Retrier.
+func loop[T any](ctx context.Context, r *Retrier, f func(ctx context.Context) (T, error)) (T, int, error) {
+	var zero T
+	attempts := 0
+	if r.budget != nil {
+		r.budget.deposit()
+	}
+
+	clock := r.getClock()
+	start := clock.Now()
+	first := r.backoff(0)
+	if r.initialDelay > 0 {
+		first = r.initialDelay
+	}
+	if r.startupSpread > 0 {
+		first = addCapped(first, randDuration(r.source(), r.startupSpread), maxDuration)
+	}
+	// The waiter is set up by the first backoff, so a first
+	// attempt that succeeds right away doesn't touch a timer. A
+	// Clock of its own still sees the wait of the first attempt.
+	var w waiter
+	defer func() {
+		if w != nil {
+			w.stop()
+		}
+	}()
+	wait := ready
+	if _, ok := clock.(realClock); !ok || first > 0 {
+		w = newWaiter(clock)
+		wait = w.after(first)
+	}
+
+	var latestErr error
+	// errs of all attempts, only kept when joined
+	var errs []error
+	// try of the backoff, which restarts on progress
+	try := 0
+	// failures in a row, without progress
+	failures := 0
+	// slept is the sum of the backoffs so far
+	var slept time.Duration
+	// lastChance is set once the backoff was cut short by the
+	// deadline of ctx
+	lastChance := false
+	// began is the start of the latest attempt, only
+	// kept when the execution time is subtracted
+	var began time.Time
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			// context cancelled
+			return zero, attempts, r.giveUp(context.Cause(ctx), attempts, clock.Now().Sub(start), latestErr, errs)
+		case <-wait:
+			if err := r.gate.wait(ctx); err != nil {
+				// context cancelled while paused
+				return zero, attempts, r.giveUp(err, attempts, clock.Now().Sub(start), latestErr, errs)
+			}
+			attempts++
+			if o := r.observer; o != nil {
+				observe(o.AttemptStarted)
+			}
+			if r.subtractExecution {
+				began = clock.Now()
+			}
+			v, err, abandoned := attempt(ctx, r, attempts, f)
+			if abandoned {
+				// context cancelled during a hard cancel attempt
+				return zero, attempts, r.giveUp(context.Cause(ctx), attempts, clock.Now().Sub(start), latestErr, errs)
+			}
+			if latestErr = err; latestErr == nil {
+				// finished ok!
+				return v, attempts, nil
+			}
+			if o := r.observer; o != nil {
+				observe(func() { o.AttemptFailed(err) })
+			}
+			if r.joinErrors {
+				errs = append(errs, latestErr)
+			}
+			if err, ok := asPermanent(latestErr); ok {
+				// no point in retrying
+				if r.joinErrors {
+					errs[len(errs)-1] = err
+				}
+				return zero, attempts, r.named(r.finalErr(err, errs), attempts, clock.Now().Sub(start))
+			}
+			if r.retryable != nil && !r.retryable(latestErr) {
+				return zero, attempts, r.named(r.finalErr(latestErr, errs), attempts, clock.Now().Sub(start))
+			}
+			if isAny(latestErr, r.abortOn) || (r.retryOn != nil && !isAny(latestErr, r.retryOn)) {
+				return zero, attempts, r.named(r.finalErr(latestErr, errs), attempts, clock.Now().Sub(start))
+			}
+		}
+
+		if r.retries != Infinite && i == r.retries {
+			// no attempts left, so no backoff either
+			break
+		}
+		if ctx.Err() != nil {
+			// f failed because ctx is done, most likely, so don't
+			// back off for an attempt that can't happen
+			return zero, attempts, r.giveUp(context.Cause(ctx), attempts, clock.Now().Sub(start), latestErr, errs)
+		}
+		if isProgress(latestErr) {
+			try = 0
+			failures = 0
+		} else if failures++; r.maxConsecutive > 0 && failures >= r.maxConsecutive {
+			// a sustained outage rather than a blip
+			return zero, attempts, r.giveUp(ErrMaxConsecutiveFailures, attempts, clock.Now().Sub(start), latestErr, errs)
+		}
+		try++
+		next := r.backoffErr(try, latestErr)
+		if r.subtractExecution {
+			if next -= clock.Now().Sub(began); next < 0 {
+				next = 0
+			}
+		}
+		if d, ok := asRetryAfter(latestErr); ok {
+			next = d
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			// Don't sleep past the deadline of ctx, but wake up
+			// shortly before it, once, for a last attempt. The
+			// deadline is wall-clock time, whatever the Clock.
+			remaining := time.Until(deadline)
+			if next >= remaining {
+				if lastChance || remaining <= 0 {
+					return zero, attempts, r.giveUp(context.DeadlineExceeded, attempts, clock.Now().Sub(start), latestErr, errs)
+				}
+				lastChance = true
+				next = remaining - remaining/10
+			}
+		}
+		if !r.until.IsZero() {
+			// Sleep up to the deadline at most, for a
+			// last attempt right at it.
+			remaining := r.until.Sub(clock.Now())
+			if remaining <= 0 {
+				return zero, attempts, r.giveUp(ErrDeadlineReached, attempts, clock.Now().Sub(start), latestErr, errs)
+			}
+			if next > remaining {
+				next = remaining
+			}
+		}
+		if r.maxElapsed > 0 && clock.Now().Sub(start)+next > r.maxElapsed {
+			// no time left for another attempt
+			return zero, attempts, r.giveUp(ErrBudgetExhausted, attempts, clock.Now().Sub(start), latestErr, errs)
+		}
+		if r.maxTotalBackoff > 0 {
+			// Keep the sum of the sleeps within the budget.
+			if left := r.maxTotalBackoff - slept; next > left {
+				if r.stopOnTotalBackoff {
+					return zero, attempts, r.giveUp(ErrBudgetExhausted, attempts, clock.Now().Sub(start), latestErr, errs)
+				}
+				next = left
+			}
+		}
+		if r.budget != nil && !r.budget.withdraw() {
+			// retried too much across the Retriers
+			return zero, attempts, r.giveUp(ErrRetryThrottled, attempts, clock.Now().Sub(start), latestErr, errs)
+		}
+		if r.onRetry != nil {
+			r.onRetry(i+1, r.named(latestErr, attempts, clock.Now().Sub(start)), next)
+		}
+		if r.logger != nil {
+			if r.name != "" {
+				r.logger.Retryf("retry: %q attempt %d failed: %v, retrying in %v", r.name, i+1, latestErr, next)
+			} else {
+				r.logger.Retryf("retry: attempt %d failed: %v, retrying in %v", i+1, latestErr, next)
+			}
+		}
+		slept += next
+		if _, ok := clock.(realClock); ok && next < r.spinThreshold {
+			if !spin(ctx, next) {
+				// context cancelled while spinning
+				return zero, attempts, r.giveUp(context.Cause(ctx), attempts, clock.Now().Sub(start), latestErr, errs)
+			}
+			wait = ready
+			continue
+		}
+		if w == nil {
+			w = newWaiter(clock)
+		}
+		wait = w.after(next)
+	}
+	// ran out of retries
+	return zero, attempts, &RetryError{
+		Attempts: attempts,
+		Elapsed:  clock.Now().Sub(start),
+		Last:     r.finalErr(latestErr, errs),
+		Name:     r.name,
+		exceeded: true,
+	}
+}
+
+// named wraps err in a *RetryError with the name of the operation of
+// DoNamed, for the errors that don't have one yet: those of the hooks,
+// and those that stop the retries early. Without a name it is err.
+func (r *Retrier) named(err error, attempts int, elapsed time.Duration) error {
+	if r.name == "" {
+		return err
+	}
+	return &RetryError{Attempts: attempts, Elapsed: elapsed, Last: err, Name: r.name}
+}
+
+// giveUp returns cause, like a context error, wrapped together with
+// a *RetryError of the attempts so far, so both can be found with
+// errors.Is and errors.As. Without a failed attempt, it is only cause.
+func (r *Retrier) giveUp(cause error, attempts int, elapsed time.Duration, latest error, errs []error) error {
+	if latest == nil {
+		return fmt.Errorf("%w", cause)
+	}
+	return fmt.Errorf("%w: %w", cause, &RetryError{
+		Attempts: attempts,
+		Elapsed:  elapsed,
+		Last:     r.finalErr(latest, errs),
+		Name:     r.name,
+	})
+}
+
+// finalErr is the error of a retry loop that gave up: the latest
+// error, or all errors joined.
+func (r *Retrier) finalErr(latest error, errs []error) error {
+	if r.joinErrors {
+		return errors.Join(errs...)
+	}
+	return latest
+}
+
+// timeout of attempt n, zero if it isn't bounded.
+func (r *Retrier) timeout(n int) time.Duration {
+	if r.attemptTimeout == nil {
+		return 0
+	}
+	return r.attemptTimeout(n)
+}
+
+// attempt calls f once, as attempt number n. With hard cancel,
+// abandoned reports that ctx was done before f returned.
+func attempt[T any](ctx context.Context, r *Retrier, n int, f func(ctx context.Context) (T, error)) (v T, err error, abandoned bool) {
+	if valid, ok := r.validator.(func(T) error); ok {
+		f = validating(f, valid)
+	}
+	if r.recover {
+		f = recovering(f)
+	}
+	actx := withAttempt(ctx, n)
+	if d := r.timeout(n); d > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(actx, d)
+		defer cancel()
+	}
+	if !r.hardCancel {
+		v, err = f(actx)
+		return v, err, false
+	}
+
+	type result struct {
+		v   T
+		err error
+		// panicked is set with the value of a panic of f
+		panicked bool
+		p        any
+	}
+	// buffered so an abandoned f doesn't block forever
+	done := make(chan result, 1)
+	go func() {
+		panicked := true
+		defer func() {
+			if panicked {
+				done <- result{panicked: true, p: recover()}
+			}
+		}()
+		v, err := f(actx)
+		panicked = false
+		done <- result{v: v, err: err}
+	}()
+	select {
+	case res := <-done:
+		if res.panicked {
+			// in the goroutine of the caller, as without
+			// the hard cancel
+			panic(res.p)
+		}
+		return res.v, res.err, false
+	case <-actx.Done():
+		if ctx.Err() != nil {
+			return v, nil, true
+		}
+		// only this attempt timed out
+		return v, actx.Err(), false
+	}
+}
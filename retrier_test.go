@@ -0,0 +1,1414 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDefaults(t *testing.T) {
+	t.Parallel()
+	r := New()
+	assert.Equal(t, defaultMaxAttempts-1, r.retries)
+	assert.Equal(t, defaultMaxBackoff, r.maxBackoff)
+	assert.NoError(t, r.err)
+}
+
+func TestRetrierDo(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond))
+	var ErrOops = errors.New("oops")
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.Equal(t, 3, n)
+	assert.True(t, errors.Is(err, ErrOops))
+}
+
+func TestRetrierDoValue(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond))
+	v, err := DoValue(context.Background(), r, func(context.Context) (int, error) {
+		n++
+		if n < 2 {
+			return 0, errors.New("oops")
+		}
+		return 42, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+	assert.Equal(t, 2, n)
+}
+
+func TestRetrierBadMaxAttempts(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(0))
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRetrierConcurrent(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond))
+
+	// One Retrier shared by many retry loops.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			err := r.Do(context.Background(), func(context.Context) error {
+				n++
+				return errors.New("oops")
+			})
+			assert.Error(t, err)
+			assert.Equal(t, 3, n)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithRampAttempts(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(10),
+		WithMaxBackoff(time.Minute),
+		WithRampAttempts(6),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	sleeps := clock.Sleeps()
+	for i := 1; i < 6; i++ {
+		assert.True(t, sleeps[i] < time.Minute, "i=%d", i)
+	}
+	for i := 6; i < 10; i++ {
+		assert.Equal(t, time.Minute, sleeps[i], "i=%d", i)
+	}
+}
+
+func TestWithRampAttemptsBad(t *testing.T) {
+	t.Parallel()
+	for _, n := range []int{-1, 0, 63} {
+		r := New(WithRampAttempts(n))
+		assert.Error(t, r.Do(context.Background(), func(context.Context) error {
+			return nil
+		}), "n=%d", n)
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(5),
+		WithMaxBackoff(8*time.Second),
+		WithJitter(JitterNone),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}, clock.Sleeps()[:5])
+
+	r = New(WithJitter(Jitter(42)))
+	assert.Error(t, r.Do(context.Background(), func(context.Context) error {
+		return nil
+	}))
+}
+
+func TestWithNoJitter(t *testing.T) {
+	t.Parallel()
+	schedule := func() []time.Duration {
+		clock := newFakeClock()
+		r := New(
+			WithMaxAttempts(7),
+			WithMaxBackoff(16*time.Second),
+			WithRampAttempts(4),
+			WithNoJitter(),
+			WithClock(clock),
+		)
+		_ = r.Do(context.Background(), func(context.Context) error {
+			return errors.New("oops")
+		})
+		return clock.Sleeps()[:7]
+	}
+
+	// The ramp still reaches the max on the fourth retry.
+	want := []time.Duration{0, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 16 * time.Second, 16 * time.Second}
+	assert.Equal(t, want, schedule())
+	assert.Equal(t, want, schedule())
+}
+
+func TestWithJitterFactor(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(100),
+		WithMaxBackoff(8*time.Second),
+		WithJitterFactor(0.2),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	sleeps := clock.Sleeps()
+	assert.True(t, sleeps[1] >= 1600*time.Millisecond && sleeps[1] <= 2400*time.Millisecond, "d=%v", sleeps[1])
+	assert.True(t, sleeps[2] >= 3200*time.Millisecond && sleeps[2] <= 4800*time.Millisecond, "d=%v", sleeps[2])
+	// At the max, it only jitters down.
+	var below bool
+	for _, d := range sleeps[3:] {
+		assert.True(t, d >= 6400*time.Millisecond && d <= 8*time.Second, "d=%v", d)
+		below = below || d < 8*time.Second
+	}
+	assert.True(t, below)
+
+	// A fraction of 0 disables the jitter.
+	clock = newFakeClock()
+	r = New(
+		WithMaxAttempts(4),
+		WithMaxBackoff(8*time.Second),
+		WithJitterFactor(0),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second, 8 * time.Second}, clock.Sleeps()[:4])
+}
+
+func TestWithCap(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(100),
+		WithMaxBackoff(8*time.Second),
+		WithCap(10*time.Second),
+		WithJitterFactor(0.5),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	// At the max, the jitter goes both ways, up to the cap.
+	var above bool
+	for _, d := range clock.Sleeps()[3:] {
+		assert.True(t, d >= 4*time.Second && d <= 10*time.Second, "d=%v", d)
+		above = above || d > 8*time.Second
+	}
+	assert.True(t, above)
+}
+
+func TestWithCapBad(t *testing.T) {
+	t.Parallel()
+	nop := func(context.Context) error { return nil }
+	assert.Error(t, New(WithCap(time.Second), WithMaxBackoff(2*time.Second)).Do(context.Background(), nop))
+	assert.Error(t, New(WithCap(-time.Second)).Do(context.Background(), nop))
+	assert.NoError(t, New(WithCap(2*time.Second), WithMaxBackoff(2*time.Second)).Do(context.Background(), nop))
+}
+
+func TestWithMultiplicativeJitter(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	r := New(WithMaxBackoff(max), WithCap(2*max), WithMultiplicativeJitter(0.2))
+	bases := []time.Duration{0, 2 * time.Second, 4 * time.Second, max}
+
+	var lo, hi float64 = 2, 0
+	for i := 0; i < 1000; i++ {
+		for try := 1; try < len(bases); try++ {
+			f := float64(r.backoff(try)) / float64(bases[try])
+			assert.True(t, f >= 0.8 && f <= 1.2, "try=%d f=%v", try, f)
+			lo, hi = math.Min(lo, f), math.Max(hi, f)
+		}
+	}
+	// The samples spread over most of [0.8, 1.2].
+	assert.True(t, lo < 0.82 && hi > 1.18, "lo=%v hi=%v", lo, hi)
+
+	// Without room above the max, it is clamped to it.
+	r = New(WithMaxBackoff(max), WithMultiplicativeJitter(1))
+	for i := 0; i < 1000; i++ {
+		d := r.backoff(5)
+		assert.True(t, d >= 0 && d <= max, "d=%v", d)
+	}
+	assert.Error(t, New(WithMultiplicativeJitter(1.5)).Do(context.Background(), func(context.Context) error { return nil }))
+}
+
+func TestWithJitterFactorBad(t *testing.T) {
+	t.Parallel()
+	nop := func(context.Context) error { return nil }
+	for _, f := range []float64{-0.1, 1.1, math.NaN()} {
+		assert.Error(t, New(WithJitterFactor(f)).Do(context.Background(), nop), "f=%v", f)
+	}
+}
+
+func TestWithMaxJitter(t *testing.T) {
+	t.Parallel()
+	const maxJitter = 100 * time.Millisecond
+	nojit := New(WithMaxBackoff(64*time.Second), WithRampAttempts(6), WithNoJitter())
+	for _, opt := range []Option{WithJitter(JitterProportional), WithJitter(JitterFull), WithJitter(JitterEqual), WithJitterFactor(0.5)} {
+		r := New(WithMaxBackoff(64*time.Second), WithRampAttempts(6), opt, WithMaxJitter(maxJitter))
+		for i := 0; i < 100; i++ {
+			for try := 1; try < 8; try++ {
+				base := nojit.backoff(try)
+				d := r.backoff(try)
+				assert.True(t, d >= base-maxJitter && d <= base+maxJitter, "try=%d base=%v d=%v", try, base, d)
+			}
+		}
+	}
+
+	// Without it, the proportional jitter is up to 5s at try 5.
+	r := New(WithMaxBackoff(64*time.Second), WithRampAttempts(6))
+	var above bool
+	for i := 0; i < 100; i++ {
+		above = above || r.backoff(5) > 32*time.Second+maxJitter
+	}
+	assert.True(t, above)
+
+	assert.Error(t, New(WithMaxJitter(-time.Second)).Do(context.Background(), func(context.Context) error { return nil }))
+}
+
+func TestWithJitterAtMaxOnly(t *testing.T) {
+	t.Parallel()
+	schedule := func(opts ...Option) []time.Duration {
+		clock := newFakeClock()
+		opts = append([]Option{WithMaxAttempts(20), WithMaxBackoff(8 * time.Second), WithClock(clock), WithJitterAtMaxOnly()}, opts...)
+		_ = New(opts...).Do(context.Background(), func(context.Context) error {
+			return errors.New("oops")
+		})
+		return clock.Sleeps()
+	}
+
+	for _, opt := range []Option{WithJitter(JitterProportional), WithJitter(JitterFull), WithJitterFactor(0.2)} {
+		sleeps := schedule(opt)
+		// The ramp below the max is exact.
+		assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second}, sleeps[:3])
+		// And the max jitters.
+		varies := false
+		for _, d := range sleeps[3:] {
+			assert.True(t, d <= 8*time.Second, "d=%v", d)
+			varies = varies || d != sleeps[3]
+		}
+		assert.True(t, varies)
+	}
+
+	// The default jitter is equal jitter at the max.
+	for _, d := range schedule()[3:] {
+		assert.True(t, d >= 4*time.Second && d < 8*time.Second, "d=%v", d)
+	}
+}
+
+func TestWithBackoff(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(10),
+		WithBackoff(Decorrelated(time.Second, 10*time.Second)),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	sleeps := clock.Sleeps()
+	assert.Zero(t, sleeps[0])
+	for _, d := range sleeps[1:] {
+		assert.True(t, d >= time.Second && d <= 10*time.Second, "d=%v", d)
+	}
+}
+
+// throttledStrategy backs off 10 times longer after errThrottled.
+type throttledStrategy struct {
+	BackoffStrategy
+}
+
+var errThrottled = errors.New("throttled")
+
+func (s throttledStrategy) BackoffErr(try int, err error) time.Duration {
+	if errors.Is(err, errThrottled) {
+		return 10 * s.Backoff(try)
+	}
+	return s.Backoff(try)
+}
+
+func TestWithBackoffErr(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(4),
+		WithBackoff(throttledStrategy{Constant(time.Second)}),
+		WithClock(clock),
+	)
+	errs := []error{errors.New("unavailable"), fmt.Errorf("429: %w", errThrottled), errors.New("unavailable"), errors.New("unavailable")}
+	n := 0
+	_ = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errs[n-1]
+	})
+	assert.Equal(t, []time.Duration{0, time.Second, 10 * time.Second, time.Second}, clock.Sleeps())
+}
+
+func TestWithBackoffLinear(t *testing.T) {
+	t.Parallel()
+	n := 0
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(5),
+		WithBackoff(Linear(time.Second, 3*time.Second)),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.Equal(t, 5, n)
+	// The four sleeps between the five attempts.
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, clock.Sleeps()[1:5])
+}
+
+func TestWithHardCancel(t *testing.T) {
+	t.Parallel()
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelFn()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	r := New(WithMaxAttempts(3), WithHardCancel())
+	start := time.Now()
+	err := r.Do(ctx, func(context.Context) error {
+		// Blocked, ignoring ctx.
+		<-release
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	// The blocked f didn't delay the return.
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestWithHardCancelValue(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithHardCancel())
+	v, err := DoValue(context.Background(), r, func(context.Context) (int, error) {
+		n++
+		if n < 2 {
+			return 0, errors.New("oops")
+		}
+		return 42, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+}
+
+func TestWithHardCancelPanic(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithHardCancel())
+	// The panic reaches the caller, not the goroutine of the attempt.
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = r.Do(context.Background(), func(context.Context) error {
+			panic("boom")
+		})
+	})
+
+	// And WithRecover still recovers it.
+	n := 0
+	r = New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithHardCancel(), WithRecover())
+	err := r.Do(context.Background(), func(context.Context) error {
+		if n++; n < 2 {
+			panic("boom")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestWithPerAttemptTimeout(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithPerAttemptTimeout(5*time.Millisecond))
+	err := r.Do(context.Background(), func(ctx context.Context) error {
+		n++
+		if n < 3 {
+			// Stuck until the attempt times out.
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestWithPerAttemptTimeoutExhausted(t *testing.T) {
+	t.Parallel()
+	n := 0
+	r := New(WithMaxAttempts(2), WithMaxBackoff(time.Millisecond), WithPerAttemptTimeout(time.Millisecond))
+	err := r.Do(context.Background(), func(ctx context.Context) error {
+		n++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, 2, n)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestWithPerAttemptTimeoutHardCancel(t *testing.T) {
+	t.Parallel()
+	var n int32
+	release := make(chan struct{})
+	defer close(release)
+
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithPerAttemptTimeout(time.Millisecond), WithHardCancel())
+	err := r.Do(context.Background(), func(ctx context.Context) error {
+		// Abandoned attempts run concurrently.
+		if atomic.AddInt32(&n, 1) < 3 {
+			// Ignores the timeout of the attempt.
+			<-release
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestWithJoinErrors(t *testing.T) {
+	t.Parallel()
+	var ErrDNS = errors.New("dns")
+	var ErrTimeout = errors.New("timeout")
+	errs := []error{ErrDNS, ErrTimeout, ErrTimeout}
+
+	n := 0
+	r := New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond), WithJoinErrors())
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errs[n-1]
+	})
+	assert.True(t, errors.Is(err, ErrDNS))
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Equal(t, "failed after 3 attempts: dns\ntimeout\ntimeout", err.Error())
+
+	// Only the last error by default.
+	n = 0
+	r = New(WithMaxAttempts(3), WithMaxBackoff(time.Millisecond))
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errs[n-1]
+	})
+	assert.False(t, errors.Is(err, ErrDNS))
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
+
+func TestWithJoinErrorsStop(t *testing.T) {
+	t.Parallel()
+	e1, e2, e3 := errors.New("e1"), errors.New("e2"), errors.New("e3")
+	for name, last := range map[string]error{
+		"permanent":     Permanent(e3),
+		"not retryable": e3,
+	} {
+		n := 0
+		r := New(
+			WithMaxAttempts(5),
+			WithMaxBackoff(time.Millisecond),
+			WithJoinErrors(),
+			WithRetryable(func(err error) bool { return err != e3 }),
+		)
+		err := r.Do(context.Background(), func(context.Context) error {
+			n++
+			return []error{e1, e2, last}[n-1]
+		})
+		// The errors of the earlier attempts are joined too.
+		assert.Equal(t, "e1\ne2\ne3", err.Error(), name)
+		assert.True(t, errors.Is(err, e1), name)
+		assert.Equal(t, 3, n, name)
+	}
+}
+
+func BenchmarkXWithContext(b *testing.B) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = XWithContext(ctx, 3, 0, func(context.Context) error {
+			return nil
+		})
+	}
+}
+
+func BenchmarkFirstAttempt(b *testing.B) {
+	ctx := context.Background()
+	nop := func(context.Context) error { return nil }
+	// The first attempt runs right away, without a timer, unless
+	// it has to wait, here for a nanosecond.
+	b.Run("immediate", func(b *testing.B) {
+		r := New()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = r.Do(ctx, nop)
+		}
+	})
+	b.Run("timer", func(b *testing.B) {
+		r := New(WithInitialDelay(time.Nanosecond))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = r.Do(ctx, nop)
+		}
+	})
+}
+
+func BenchmarkSpinThreshold(b *testing.B) {
+	ctx := context.Background()
+	errOops := errors.New("oops")
+	// 3 backoffs of 10µs, with a timer, or spinning.
+	for _, threshold := range []time.Duration{0, time.Millisecond} {
+		b.Run(fmt.Sprintf("threshold=%v", threshold), func(b *testing.B) {
+			r := New(WithBackoff(Constant(10*time.Microsecond)), WithSpinThreshold(threshold))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				n := 0
+				_ = r.Do(ctx, func(context.Context) error {
+					if n++; n < 4 {
+						return errOops
+					}
+					return nil
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkXWithContextRetries(b *testing.B) {
+	ctx := context.Background()
+	errOops := errors.New("oops")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		_ = XWithContext(ctx, 3, 0, func(context.Context) error {
+			if n++; n < 4 {
+				return errOops
+			}
+			return nil
+		})
+	}
+}
+
+func TestXWithContextAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	ctx := context.Background()
+	errOops := errors.New("oops")
+
+	// The timer of the backoffs is reused, so the
+	// retries don't allocate a timer each.
+	allocs := testing.AllocsPerRun(100, func() {
+		n := 0
+		_ = XWithContext(ctx, 3, 0, func(context.Context) error {
+			if n++; n < 4 {
+				return errOops
+			}
+			return nil
+		})
+	})
+	assert.LessOrEqual(t, allocs, 10.0)
+
+	allocs = testing.AllocsPerRun(100, func() {
+		_ = XWithContext(ctx, 3, 0, func(context.Context) error {
+			return nil
+		})
+	})
+	assert.LessOrEqual(t, allocs, 2.0)
+}
+
+func TestWithMinBackoff(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(10),
+		WithMinBackoff(50*time.Millisecond),
+		WithMaxBackoff(time.Second),
+		WithJitter(JitterNone),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	ms := time.Millisecond
+	want := []time.Duration{0, 50 * ms, 100 * ms, 200 * ms, 400 * ms, 800 * ms, time.Second, time.Second}
+	assert.Equal(t, want, clock.Sleeps()[:len(want)])
+}
+
+func TestWithMinBackoffJitter(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(10),
+		WithMinBackoff(50*time.Millisecond),
+		WithMaxBackoff(time.Second),
+		WithClock(clock),
+	)
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+
+	// The first retry starts at min, plus the jitter.
+	sleeps := clock.Sleeps()
+	assert.True(t, sleeps[1] >= 50*time.Millisecond && sleeps[1] < 75*time.Millisecond, "d=%v", sleeps[1])
+	for _, d := range sleeps {
+		assert.True(t, d <= time.Second)
+	}
+}
+
+func TestWithMinBackoffBad(t *testing.T) {
+	t.Parallel()
+	nop := func(context.Context) error { return nil }
+	assert.Error(t, New(WithMinBackoff(0)).Do(context.Background(), nop))
+	assert.Error(t, New(WithMinBackoff(2*time.Second), WithMaxBackoff(time.Second)).Do(context.Background(), nop))
+}
+
+func TestWithInitialDelay(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(WithInitialDelay(time.Second), WithClock(clock))
+	err := r.Do(context.Background(), func(context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second}, clock.Sleeps())
+
+	// Without it, the first attempt starts immediately.
+	clock = newFakeClock()
+	r = New(WithClock(clock))
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return nil
+	})
+	assert.Equal(t, []time.Duration{0}, clock.Sleeps())
+}
+
+func TestWithInitialDelayCancelled(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelFn()
+	r := New(WithInitialDelay(time.Minute))
+	err := r.Do(ctx, func(context.Context) error {
+		n++
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Zero(t, n)
+}
+
+func TestWithMultiplier(t *testing.T) {
+	t.Parallel()
+	schedule := func(opts ...Option) []time.Duration {
+		clock := newFakeClock()
+		opts = append(opts,
+			WithMaxAttempts(5),
+			WithMaxBackoff(9*time.Second),
+			WithJitter(JitterNone),
+			WithClock(clock),
+		)
+		_ = New(opts...).Do(context.Background(), func(context.Context) error {
+			return errors.New("oops")
+		})
+		return clock.Sleeps()
+	}
+
+	// The ramp reaches the max on the third retry either way, but
+	// 1.5x starts higher and grows slower than doubling.
+	doubling := schedule()
+	assert.Equal(t, []time.Duration{0, 9 * time.Second / 4, 9 * time.Second / 2, 9 * time.Second}, doubling[:4])
+	got := schedule(WithMultiplier(1.5))
+	assert.Equal(t, []time.Duration{0, 4 * time.Second, 6 * time.Second, 9 * time.Second}, got[:4])
+	assert.NotEqual(t, doubling[1:4], got[1:4])
+
+	// From a min, the backoff grows by m until the max.
+	got = schedule(WithMultiplier(3), WithMinBackoff(time.Second))
+	assert.Equal(t, []time.Duration{0, time.Second, 3 * time.Second, 9 * time.Second, 9 * time.Second}, got[:5])
+}
+
+func TestWithMultiplierBad(t *testing.T) {
+	t.Parallel()
+	nop := func(context.Context) error { return nil }
+	for _, m := range []float64{-1, 0, 1, math.NaN(), math.Inf(1)} {
+		assert.Error(t, New(WithMultiplier(m)).Do(context.Background(), nop), "m=%v", m)
+	}
+}
+
+func TestWithMaxAttemptsInfinite(t *testing.T) {
+	t.Parallel()
+	n := 0
+	clock := newFakeClock()
+	r := New(WithMaxAttempts(Infinite), WithMaxBackoff(8*time.Second), WithClock(clock))
+	err := r.Do(context.Background(), func(context.Context) error {
+		if n++; n < 100 {
+			return errors.New("oops")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, n)
+	// The backoff stays at the max after the ramp.
+	for _, d := range clock.Sleeps()[3:] {
+		assert.Equal(t, 8*time.Second, d)
+	}
+}
+
+func TestWithOnGiveUpAndOnSuccess(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	var (
+		gaveUp    []int
+		succeeded []int
+		lastErr   error
+	)
+	r := New(
+		WithMaxAttempts(3),
+		WithMaxBackoff(0),
+		WithOnGiveUp(func(attempts int, _ time.Duration, err error) {
+			gaveUp = append(gaveUp, attempts)
+			lastErr = err
+		}),
+		WithOnSuccess(func(attempts int, _ time.Duration) {
+			succeeded = append(succeeded, attempts)
+		}),
+	)
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		if n++; n < 2 {
+			return ErrOops
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, succeeded)
+	assert.Empty(t, gaveUp)
+
+	err = r.Do(context.Background(), func(context.Context) error {
+		return ErrOops
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []int{3}, gaveUp)
+	assert.Equal(t, err, lastErr)
+	assert.Equal(t, []int{2}, succeeded)
+
+	// A Permanent error gives up too.
+	err = r.Do(context.Background(), func(context.Context) error {
+		return Permanent(ErrOops)
+	})
+	assert.Equal(t, ErrOops, err)
+	assert.Equal(t, []int{3, 1}, gaveUp)
+
+	// And so does a cancelled context, during the backoff.
+	ctx, cancelFn := context.WithCancel(context.Background())
+	r = New(
+		WithMaxBackoff(time.Minute),
+		WithOnGiveUp(func(attempts int, _ time.Duration, err error) {
+			gaveUp = append(gaveUp, attempts)
+			lastErr = err
+		}),
+	)
+	err = r.Do(ctx, func(context.Context) error {
+		cancelFn()
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, []int{3, 1, 1}, gaveUp)
+	assert.Equal(t, err, lastErr)
+}
+
+func TestWithOnGiveUpElapsed(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	var (
+		slept   time.Duration
+		elapsed time.Duration
+	)
+	r := New(
+		WithMaxAttempts(4),
+		WithMaxBackoff(8*time.Second),
+		WithClock(clock),
+		WithOnRetry(func(_ int, _ error, next time.Duration) {
+			slept += next
+		}),
+		WithOnGiveUp(func(_ int, d time.Duration, _ error) {
+			elapsed = d
+		}),
+		WithOnSuccess(func(_ int, d time.Duration) {
+			elapsed = d
+		}),
+	)
+	err := r.Do(context.Background(), func(context.Context) error {
+		<-clock.After(time.Second)
+		return errors.New("oops")
+	})
+	// The 3 backoffs, and the 4 attempts of 1s.
+	assert.True(t, slept > 0)
+	assert.Equal(t, slept+4*time.Second, elapsed)
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, elapsed, rerr.Elapsed)
+
+	n := 0
+	slept = 0
+	assert.NoError(t, r.Do(context.Background(), func(context.Context) error {
+		if n++; n < 3 {
+			return errors.New("oops")
+		}
+		return nil
+	}))
+	assert.Equal(t, slept, elapsed)
+
+	// With the real clock, too.
+	r = New(WithMaxAttempts(2), WithBackoff(Constant(10*time.Millisecond)), WithOnGiveUp(func(_ int, d time.Duration, _ error) {
+		elapsed = d
+	}))
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.True(t, elapsed >= 10*time.Millisecond, "elapsed=%v", elapsed)
+}
+
+func TestWithOnGiveUpInvalid(t *testing.T) {
+	t.Parallel()
+	called := false
+	r := New(WithMaxBackoff(-1), WithOnGiveUp(func(int, time.Duration, error) {
+		called = true
+	}))
+	assert.Error(t, r.Do(context.Background(), func(context.Context) error {
+		return nil
+	}))
+	assert.False(t, called)
+}
+
+func TestWithStartupSpread(t *testing.T) {
+	t.Parallel()
+	var firsts []time.Duration
+	for i := 0; i < 20; i++ {
+		clock := newFakeClock()
+		r := New(WithStartupSpread(time.Second), WithInitialDelay(time.Second), WithClock(clock))
+		_ = r.Do(context.Background(), func(context.Context) error {
+			return nil
+		})
+		d := clock.Sleeps()[0]
+		assert.True(t, d >= time.Second && d < 2*time.Second, "d=%v", d)
+		firsts = append(firsts, d)
+	}
+	// The first attempts are spread out.
+	var spread bool
+	for _, d := range firsts {
+		spread = spread || d != firsts[0]
+	}
+	assert.True(t, spread)
+}
+
+func TestWithMaxTotalBackoff(t *testing.T) {
+	t.Parallel()
+	const budget = 9 * time.Second
+	n := 0
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(6),
+		WithMaxBackoff(4*time.Second),
+		WithNoJitter(),
+		WithMaxTotalBackoff(budget),
+		WithClock(clock),
+	)
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 6, n)
+
+	// 1s, 2s, 4s, then the 2s left, then none.
+	sleeps := clock.Sleeps()[1:6]
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 2 * time.Second, 0}, sleeps)
+	var sum time.Duration
+	for _, d := range sleeps {
+		sum += d
+	}
+	assert.Equal(t, budget, sum)
+}
+
+func TestWithMaxTotalBackoffStop(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	clock := newFakeClock()
+	r := New(
+		WithMaxAttempts(6),
+		WithMaxBackoff(4*time.Second),
+		WithNoJitter(),
+		WithMaxTotalBackoffStop(9*time.Second),
+		WithClock(clock),
+	)
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+	assert.True(t, errors.Is(err, ErrOops))
+	// The fourth backoff of 4s would exceed the budget.
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}, clock.Sleeps())
+
+	assert.Error(t, New(WithMaxTotalBackoff(0)).Do(context.Background(), func(context.Context) error {
+		return nil
+	}))
+}
+
+// countingStrategy counts the calls of Backoff.
+type countingStrategy struct {
+	calls int
+}
+
+func (s *countingStrategy) Backoff(int) time.Duration {
+	s.calls++
+	return 0
+}
+
+func TestNoBackoffAfterLastAttempt(t *testing.T) {
+	t.Parallel()
+	s := &countingStrategy{}
+	clock := newFakeClock()
+	r := New(WithMaxAttempts(4), WithBackoff(s), WithClock(clock))
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	// One wait before each of the four attempts, none after the last.
+	assert.Equal(t, 4, s.calls)
+	assert.Len(t, clock.Sleeps(), 4)
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+	base := New(WithMaxAttempts(2), WithMaxBackoff(0))
+	critical := base.Clone(WithMaxAttempts(5))
+
+	count := func(r *Retrier) int {
+		n := 0
+		_ = r.Do(context.Background(), func(context.Context) error {
+			n++
+			return errors.New("oops")
+		})
+		return n
+	}
+	assert.Equal(t, 5, count(critical))
+	// The parent is untouched.
+	assert.Equal(t, 2, count(base))
+	assert.Equal(t, 2, count(base.Clone()))
+
+	// An invalid override fails the clone only.
+	bad := base.Clone(WithMaxAttempts(0))
+	assert.Error(t, bad.Do(context.Background(), func(context.Context) error { return nil }))
+	assert.NoError(t, base.Do(context.Background(), func(context.Context) error { return nil }))
+}
+
+func TestWithRetryOn(t *testing.T) {
+	t.Parallel()
+	var (
+		ErrBusy  = errors.New("busy")
+		ErrOther = errors.New("other")
+	)
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0), WithRetryOn(ErrBusy))
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return fmt.Errorf("wrapped: %w", ErrBusy)
+	})
+	assert.True(t, errors.Is(err, ErrBusy))
+	assert.Equal(t, 3, n)
+
+	n = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOther
+	})
+	assert.Equal(t, ErrOther, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestWithAbortOn(t *testing.T) {
+	t.Parallel()
+	var (
+		ErrBusy    = errors.New("busy")
+		ErrInvalid = errors.New("invalid")
+	)
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0), WithAbortOn(ErrInvalid))
+
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrBusy
+	})
+	assert.True(t, errors.Is(err, ErrBusy))
+	assert.Equal(t, 3, n)
+
+	n = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrInvalid
+	})
+	assert.Equal(t, ErrInvalid, err)
+	assert.Equal(t, 1, n)
+
+	// The abort wins over the retry.
+	r = New(WithMaxAttempts(3), WithMaxBackoff(0), WithRetryOn(ErrInvalid), WithAbortOn(ErrInvalid))
+	n = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrInvalid
+	})
+	assert.Equal(t, ErrInvalid, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestWithRandomAttempts(t *testing.T) {
+	t.Parallel()
+	count := func(r *Retrier) int {
+		n := 0
+		_ = r.Do(context.Background(), func(context.Context) error {
+			n++
+			return errors.New("oops")
+		})
+		return n
+	}
+
+	r := New(WithRandomAttempts(2, 5), WithMaxBackoff(0))
+	seen := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		n := count(r)
+		assert.True(t, n >= 2 && n <= 5, "n=%d", n)
+		seen[n] = true
+	}
+	assert.Len(t, seen, 4)
+
+	// A fixed seed makes it reproducible.
+	schedule := func() []int {
+		r := New(WithRandomAttempts(1, 10), WithMaxBackoff(0), WithRand(rand.New(rand.NewSource(42))))
+		var ns []int
+		for i := 0; i < 10; i++ {
+			ns = append(ns, count(r))
+		}
+		return ns
+	}
+	assert.Equal(t, schedule(), schedule())
+
+	nop := func(context.Context) error { return nil }
+	assert.Error(t, New(WithRandomAttempts(0, 3)).Do(context.Background(), nop))
+	assert.Error(t, New(WithRandomAttempts(3, 2)).Do(context.Background(), nop))
+	assert.Equal(t, 1, count(New(WithRandomAttempts(1, 1))))
+}
+
+func TestWithPerAttemptTimeoutFunc(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(4), WithMaxBackoff(0), WithPerAttemptTimeoutFunc(func(attempt int) time.Duration {
+		if attempt == 2 {
+			return 0
+		}
+		return time.Duration(attempt) * time.Minute
+	}))
+	var timeouts []time.Duration
+	_ = r.Do(context.Background(), func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			timeouts = append(timeouts, 0)
+		} else {
+			timeouts = append(timeouts, time.Until(deadline).Round(time.Minute))
+		}
+		return errors.New("oops")
+	})
+	assert.Equal(t, []time.Duration{time.Minute, 0, 3 * time.Minute, 4 * time.Minute}, timeouts)
+
+	// A nil func removes the timeout.
+	r = New(WithPerAttemptTimeout(time.Minute), WithPerAttemptTimeoutFunc(nil))
+	_ = r.Do(context.Background(), func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+		return nil
+	})
+}
+
+func TestWithAbortContext(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	abort, cancel := context.WithCancel(context.Background())
+	r := New(WithMaxAttempts(Infinite), WithMaxBackoff(time.Minute), WithAbortContext(abort))
+
+	// The loop is sleeping a minute when abort is cancelled.
+	n := 0
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, time.Since(start) < time.Minute)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.Equal(t, 1, n)
+
+	// Once aborted, the later calls don't retry either.
+	n = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, n <= 1, "n=%d", n)
+
+	// The context of f has the cause of abort.
+	var ErrShutdown = errors.New("shutting down")
+	abort, cancelCause := context.WithCancelCause(context.Background())
+	r = New(WithAbortContext(abort))
+	err = r.Do(context.Background(), func(ctx context.Context) error {
+		cancelCause(ErrShutdown)
+		<-ctx.Done()
+		assert.Equal(t, ErrShutdown, context.Cause(ctx))
+		return ctx.Err()
+	})
+	assert.True(t, errors.Is(err, ErrShutdown))
+}
+
+func TestWithResultValidator(t *testing.T) {
+	t.Parallel()
+	var ErrEmpty = errors.New("empty body")
+	r := New(WithMaxAttempts(3), WithMaxBackoff(0), WithResultValidator(func(body []byte) error {
+		if len(body) == 0 {
+			return ErrEmpty
+		}
+		return nil
+	}))
+
+	// The first response is empty, the second one is valid.
+	n := 0
+	body, err := DoValue(context.Background(), r, func(context.Context) ([]byte, error) {
+		n++
+		if n == 1 {
+			return nil, nil
+		}
+		return []byte("ok"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ok"), body)
+	assert.Equal(t, 2, n)
+
+	// Always invalid gives up with the error of the validator.
+	body, err = DoValue(context.Background(), r, func(context.Context) ([]byte, error) {
+		return []byte{}, nil
+	})
+	assert.True(t, errors.Is(err, ErrEmpty))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Nil(t, body)
+
+	// Results of other types are an error, before the first attempt.
+	n = 0
+	assert.EqualError(t, r.Do(context.Background(), func(context.Context) error {
+		n++
+		return nil
+	}), "result validator func([]uint8) error doesn't match the results of type struct {}")
+	_, err = DoValue(context.Background(), r, func(context.Context) (string, error) {
+		n++
+		return "ok", nil
+	})
+	assert.EqualError(t, err, "result validator func([]uint8) error doesn't match the results of type string")
+	assert.Zero(t, n)
+}
+
+func TestWithSubtractExecutionTime(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	r := New(WithMaxAttempts(3), WithBackoff(Constant(time.Second)), WithSubtractExecutionTime(), WithClock(clock))
+	n := 0
+	_ = r.Do(context.Background(), func(context.Context) error {
+		// f takes 300ms, then 2s.
+		n++
+		if n == 1 {
+			<-clock.After(300 * time.Millisecond)
+		} else {
+			<-clock.After(2 * time.Second)
+		}
+		return errors.New("oops")
+	})
+	// The 1s backoff sleeps 700ms after the 300ms of f, and not
+	// at all after an f longer than the backoff.
+	assert.Equal(t, []time.Duration{0, 300 * time.Millisecond, 700 * time.Millisecond, 2 * time.Second, 0, 2 * time.Second}, clock.Sleeps())
+
+	// RetryAfter isn't shortened.
+	clock = newFakeClock()
+	r = New(WithMaxAttempts(2), WithBackoff(Constant(time.Second)), WithSubtractExecutionTime(), WithClock(clock))
+	_ = r.Do(context.Background(), func(context.Context) error {
+		<-clock.After(300 * time.Millisecond)
+		return RetryAfter(time.Second, errors.New("oops"))
+	})
+	assert.Equal(t, []time.Duration{0, 300 * time.Millisecond, time.Second, 300 * time.Millisecond}, clock.Sleeps())
+}
+
+func TestDoNamed(t *testing.T) {
+	t.Parallel()
+	var lines []string
+	r := New(
+		WithMaxAttempts(2),
+		WithMaxBackoff(time.Second),
+		WithNoJitter(),
+		WithClock(newFakeClock()),
+		WithLogger(LoggerFunc(func(format string, args ...any) {
+			lines = append(lines, fmt.Sprintf(format, args...))
+		})),
+	)
+	err := r.DoNamed(context.Background(), "fetch-user", func(ctx context.Context) error {
+		name, ok := NameFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "fetch-user", name)
+		return errors.New("oops")
+	})
+	assert.EqualError(t, err, `operation "fetch-user" failed after 2 attempts: oops`)
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, "fetch-user", rerr.Name)
+	assert.Equal(t, []string{`retry: "fetch-user" attempt 1 failed: oops, retrying in 250ms`}, lines)
+
+	// The name doesn't stick to the Retrier.
+	err = r.Do(context.Background(), func(ctx context.Context) error {
+		_, ok := NameFromContext(ctx)
+		assert.False(t, ok)
+		return errors.New("oops")
+	})
+	assert.EqualError(t, err, "failed after 2 attempts: oops")
+}
+
+func TestDoNamedHooks(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	var retried, gaveUp []string
+	name := func(err error) string {
+		var rerr *RetryError
+		if !errors.As(err, &rerr) {
+			return ""
+		}
+		return rerr.Name
+	}
+	r := New(
+		WithMaxAttempts(3),
+		WithMaxBackoff(0),
+		WithOnRetry(func(_ int, err error, _ time.Duration) {
+			assert.True(t, errors.Is(err, ErrOops))
+			retried = append(retried, name(err))
+		}),
+		WithOnGiveUp(func(_ int, _ time.Duration, err error) {
+			gaveUp = append(gaveUp, name(err))
+		}),
+	)
+	_ = r.DoNamed(context.Background(), "fetch-user", func(context.Context) error {
+		return ErrOops
+	})
+	assert.Equal(t, []string{"fetch-user", "fetch-user"}, retried)
+	assert.Equal(t, []string{"fetch-user"}, gaveUp)
+
+	// A Permanent error keeps the name too.
+	err := r.DoNamed(context.Background(), "save-user", func(context.Context) error {
+		return Permanent(ErrOops)
+	})
+	assert.EqualError(t, err, `operation "save-user" failed after 1 attempts: oops`)
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.False(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, []string{"fetch-user", "save-user"}, gaveUp)
+
+	// Without a name, the hooks get the error of f.
+	retried = nil
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return ErrOops
+	})
+	assert.Equal(t, []string{"", ""}, retried)
+}
+
+func TestWithMaxConsecutiveFailures(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	r := New(WithMaxAttempts(Infinite), WithMaxBackoff(0), WithMaxConsecutiveFailures(3))
+
+	// fail, fail, progress, fail, fail, fail
+	n := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		if n++; n == 3 {
+			return Progress(ErrOops)
+		}
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrMaxConsecutiveFailures))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.False(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 6, n)
+
+	// The max attempts can run out first.
+	n = 0
+	r = New(WithMaxAttempts(2), WithMaxBackoff(0), WithMaxConsecutiveFailures(3))
+	err = r.Do(context.Background(), func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.False(t, errors.Is(err, ErrMaxConsecutiveFailures))
+	assert.Equal(t, 2, n)
+
+	assert.Error(t, New(WithMaxConsecutiveFailures(0)).Do(context.Background(), func(context.Context) error { return nil }))
+}
+
+func TestWithSpinThreshold(t *testing.T) {
+	t.Parallel()
+	r := New(WithMaxAttempts(5), WithBackoff(Constant(100*time.Microsecond)), WithSpinThreshold(time.Millisecond))
+	n := 0
+	start := time.Now()
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 5, n)
+	// The 4 backoffs still wait.
+	assert.True(t, time.Since(start) >= 400*time.Microsecond)
+
+	// The spin stops with ctx.
+	r = New(WithMaxAttempts(Infinite), WithBackoff(Constant(time.Hour)), WithSpinThreshold(2*time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = r.Do(ctx, func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// A Clock of its own isn't spun.
+	clock := newFakeClock()
+	r = New(WithMaxAttempts(2), WithBackoff(Constant(time.Hour)), WithSpinThreshold(2*time.Hour), WithClock(clock))
+	_ = r.Do(context.Background(), func(context.Context) error {
+		return errors.New("oops")
+	})
+	assert.Equal(t, []time.Duration{0, time.Hour}, clock.Sleeps())
+
+	assert.Error(t, New(WithSpinThreshold(-1)).Do(context.Background(), func(context.Context) error { return nil }))
+}
@@ -21,8 +21,7 @@ package retry
 import (
 	"context"
 	"errors"
-	"fmt"
-	"math/rand"
+	"math"
 	"time"
 )
 
@@ -39,7 +38,13 @@ import (
 //
 // The use of "return err != nil" is an ideomatic way of
 // returning true, keep trying, when the error is not nil.
+//
+// A negative x is treated as 0, so f is always called at
+// least once.
 func X(x int, maxBackoff time.Duration, f func() bool) {
+	if x < 0 {
+		x = 0
+	}
 	for i := 0; i <= x; i++ {
 		time.Sleep(backoff(i, maxBackoff))
 		if !f() {
@@ -48,14 +53,135 @@ func X(x int, maxBackoff time.Duration, f func() bool) {
 	}
 }
 
+// XErr is like X, but f returns an error instead of a bool, and XErr
+// returns it: nil once f succeeded, or a *RetryError wrapped around
+// the last error of f if all attempts failed. It has no context, for
+// quick scripts, see XWithContext for one that can be cancelled.
+//
+// Example 1:
+//    err := retry.XErr(3, 5*time.Second, func() error {
+//        return DoSomething()
+//    })
+func XErr(x int, maxBackoff time.Duration, f func() error) error {
+	return XWithContext(context.Background(), x, maxBackoff, func(context.Context) error {
+		return f()
+	})
+}
+
+// ErrStopped is returned by XWithStop when stop is closed before f
+// succeeded.
+var ErrStopped = errors.New("retry stopped")
+
+// XWithStop is like XWithContext, but for code that predates the
+// context package, and uses a stop channel instead. Closing stop
+// cancels the retries between the attempts, and XWithStop returns
+// ErrStopped. An attempt that is running when stop is closed is
+// allowed to complete first.
+//
+// Example 1:
+//    err := retry.XWithStop(w.stop, 3, 5*time.Second, func() error {
+//        return DoSomething()
+//    })
+//    if err == retry.ErrStopped {
+//        return
+//    }
+func XWithStop(stop <-chan struct{}, x int, maxBackoff time.Duration, f func() error) error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-stop:
+			cancel(ErrStopped)
+		case <-ctx.Done():
+		}
+	}()
+
+	err := XWithContext(ctx, x, maxBackoff, func(context.Context) error {
+		return f()
+	})
+	if errors.Is(err, ErrStopped) {
+		return ErrStopped
+	}
+	return err
+}
+
+// ErrMaxRetries is matched by the error of XContext, with errors.Is,
+// when f still asked to keep trying on the last attempt. It is
+// ErrMaxRetriesExceeded.
+var ErrMaxRetries = ErrMaxRetriesExceeded
+
+// XContext is like X, but stops between attempts when ctx is done,
+// returning ctx's error. It returns nil when f returns false, and an
+// error matching ErrMaxRetries when f still returns true after x+1
+// calls, a *RetryError with the attempts.
+//
+// Example 1:
+//    err := retry.XContext(ctx, 3, 5*time.Second, func() bool {
+//        return DoSomething() != nil
+//    })
+//    if errors.Is(err, retry.ErrMaxRetries) {
+//        return
+//    }
+func XContext(ctx context.Context, x int, maxBackoff time.Duration, f func() bool) error {
+	return XWithContext(ctx, x, maxBackoff, func(context.Context) error {
+		if f() {
+			return ErrMaxRetries
+		}
+		return nil
+	})
+}
+
+// XBool is like XContext, but f also gets ctx, and the outcome is
+// reported as a bool. Like X, f returns true to keep trying, and
+// false once it succeeded. XBool in turn returns true if f succeeded,
+// that is f returned false, and false if f still asked to keep trying
+// after x+1 calls, or ctx was done first. Note the inversion: f's
+// false is XBool's true.
+//
+// Example 1:
+//    ok := retry.XBool(ctx, 3, 5*time.Second, func(ctx context.Context) bool {
+//        return DoSomething(ctx) != nil
+//    })
+//    if !ok {
+//        // All the attempts failed.
+//    }
+func XBool(ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) bool) bool {
+	err := XWithContext(ctx, x, maxBackoff, func(ctx context.Context) error {
+		if f(ctx) {
+			return ErrMaxRetries
+		}
+		return nil
+	})
+	return err == nil
+}
+
+// Infinite as x, the number of retries, of XWithContext and the other
+// functions of the package keeps retrying until f succeeds or ctx is
+// done, with the backoff pinned at the max after the ramp. It is not
+// -1: a negative x is still an error, so be sure to cancel ctx, for
+// example in a background daemon that should give up on shutdown.
+const Infinite = math.MaxInt
+
 // XWithContext runs function f until f returns nil or the
 // number of retries exceeds x. Never more than x+1 calls of f
 // are done. Calls to f have a sleep duration between them.
-// XWithContext will return a wrapped error around f's errors
-// if all attempts fail.
+// XWithContext will return a *RetryError wrapped around f's
+// last error if all attempts fail.
 // The attempts can be cancelled with ctx. If f does not cancel
 // when ctx is done, then the currently-running f will be allowed
-// to complete first.
+// to complete first. When ctx has a deadline and the backoff would
+// sleep past it, the retries wake up shortly before the deadline
+// instead, for one last attempt, and then give up with
+// context.DeadlineExceeded. The backoffs before that keep their
+// durations. When ctx stops
+// the retries, the error of ctx is returned wrapped together with the
+// *RetryError of the attempts so far, so errors.Is finds both the
+// context.Canceled or context.DeadlineExceeded, and the last error of
+// f. An attempt that fails once ctx is done, usually with the error
+// of ctx, gives up right away, without a backoff. The error of ctx is
+// its context.Cause, so a ctx cancelled with context.WithCancelCause
+// returns that cause instead. Returning an error wrapped with
+// Permanent from f stops the retries early.
 //
 // Example 1:
 //    retry.XWithContext(ctx, 3, 5*time.Second, func(ctx context.Context) error {
@@ -63,37 +189,310 @@ func X(x int, maxBackoff time.Duration, f func() bool) {
 //        return err
 //    })
 func XWithContext(ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) error) error {
-	if x < 0 {
-		return errors.New("x cannot be less than 0")
+	return (&Retrier{retries: x, maxBackoff: maxBackoff}).Do(ctx, f)
+}
+
+// XWithContextAll is like XWithContext, but also returns the errors
+// of every failed attempt of f, in order, for example to show each of
+// them in a report. The first error is the one XWithContext returns.
+// There are at most x+1 errors, and none when the first attempt
+// succeeded. The error first order of the results is unusual, but
+// the errors slice is extra detail, not the outcome.
+//
+// Example 1:
+//    err, errs := retry.XWithContextAll(ctx, 3, 5*time.Second, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+//    for i, err := range errs {
+//        log.Printf("attempt %d: %v", i+1, err)
+//    }
+func XWithContextAll(ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) error) (error, []error) {
+	var errs []error
+	if x >= 0 && x < 64 {
+		// exactly the most attempts for the common small x
+		errs = make([]error, 0, x+1)
 	}
-	if maxBackoff < 0 {
-		return errors.New("maxBackoff cannot be less than 0")
+	err := XWithContext(ctx, x, maxBackoff, func(ctx context.Context) error {
+		err := f(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return err
+	})
+	return err, errs
+}
+
+// Do runs function f until f returns a nil error or the number
+// of retries exceeds x, and returns the value of the successful
+// call. It has the same backoff, cancellation and error wrapping
+// semantics as XWithContext. If all attempts fail, or ctx is
+// cancelled, the zero value of T is returned with the error.
+//
+// Example 1:
+//    body, err := retry.Do(ctx, 3, 5*time.Second, func(ctx context.Context) ([]byte, error) {
+//        return Fetch(ctx, url)
+//    })
+func Do[T any](ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) (T, error)) (T, error) {
+	return DoValue(ctx, &Retrier{retries: x, maxBackoff: maxBackoff}, f)
+}
+
+// DoN is like Do, but also returns the number of calls of f, for
+// example to log how many tries a call of an API took. The count is
+// at least 1 when x >= 0, unless ctx is done before the first attempt.
+//
+// Example 1:
+//    user, n, err := retry.DoN(ctx, 3, 5*time.Second, func(ctx context.Context) (*User, error) {
+//        return client.GetUser(ctx, id)
+//    })
+//    log.Printf("got user after %d attempts", n)
+func DoN[T any](ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) (T, error)) (T, int, error) {
+	return run(ctx, &Retrier{retries: x, maxBackoff: maxBackoff}, f)
+}
+
+// Wrap returns a function that calls f with the retries of
+// XWithContext, so a retrying version of a client method can be built
+// once, and reused at every call site. Each call of the returned
+// function is an independent retry loop.
+//
+// Example 1:
+//    ping := retry.Wrap(3, 5*time.Second, client.Ping)
+//    err := ping(ctx)
+func Wrap(x int, maxBackoff time.Duration, f func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return XWithContext(ctx, x, maxBackoff, f)
 	}
+}
 
-	timer := time.NewTimer(0)
-	defer timer.Stop()
+// WrapValue is like Wrap, for a function that returns a value,
+// see Do.
+//
+// Example 1:
+//    getUser := retry.WrapValue(3, 5*time.Second, func(ctx context.Context) (*User, error) {
+//        return client.GetUser(ctx, id)
+//    })
+//    user, err := getUser(ctx)
+func WrapValue[T any](x int, maxBackoff time.Duration, f func(ctx context.Context) (T, error)) func(ctx context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		return Do(ctx, x, maxBackoff, f)
+	}
+}
 
-	var latestErr error
-	for i := 0; i <= x; i++ {
-		select {
-		case <-ctx.Done():
-			// context cancelled
-			if !timer.Stop() {
-				// drain the timer chan
-				<-timer.C
-			}
-			return fmt.Errorf("%w", ctx.Err())
-		case <-timer.C:
-			if latestErr = f(ctx); latestErr == nil {
-				// finished ok!
-				return nil
-			}
+// DoWhile is like Do, but retries while retry returns true for the
+// result and error of f, for example while a status is still PENDING,
+// even though f succeeded. It generalizes the retries on errors to
+// retries on values. f stops once retry returns false, and DoWhile
+// returns its result and error as they are. If f is still retried
+// after x+1 calls, or ctx is done, DoWhile returns the last result of
+// f, and a *RetryError wrapped around the last error, or
+// ErrConditionNotMet if f succeeded.
+//
+// Example 1:
+//    job, err := retry.DoWhile(ctx, 30, 2*time.Second, func(ctx context.Context) (*Job, error) {
+//        return client.GetJob(ctx, id)
+//    }, func(job *Job, err error) bool {
+//        return err != nil || job.Status == "PENDING"
+//    })
+func DoWhile[T any](ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) (T, error), retry func(T, error) bool) (T, error) {
+	var last T
+	_, err := DoValue(ctx, &Retrier{retries: x, maxBackoff: maxBackoff}, func(ctx context.Context) (T, error) {
+		v, err := f(ctx)
+		last = v
+		switch {
+		case !retry(v, err):
+			return v, Permanent(err)
+		case err == nil:
+			return v, ErrConditionNotMet
 		}
+		return v, err
+	})
+	return last, err
+}
+
+// ErrBudgetExhausted is returned, wrapped together with the last
+// error of f, when XWithDeadline runs out of time for another attempt.
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// XWithDeadline is like XWithContext, but also stops scheduling
+// new attempts once the next one could not start before maxElapsed
+// has passed since the first attempt. In that case the last error
+// of f is returned wrapped with ErrBudgetExhausted. An attempt that
+// is already running is allowed to complete, even if it takes f past
+// maxElapsed.
+//
+// Example 1:
+//    // Retry up to 10 times, but give up after 30 seconds.
+//    err := retry.XWithDeadline(ctx, 10, 5*time.Second, 30*time.Second, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+//    if errors.Is(err, retry.ErrBudgetExhausted) {
+//        // Ran out of time before running out of retries.
+//    }
+func XWithDeadline(ctx context.Context, x int, maxBackoff, maxElapsed time.Duration, f func(ctx context.Context) error) error {
+	if maxElapsed <= 0 {
+		return errors.New("maxElapsed must be greater than 0")
+	}
+	return (&Retrier{retries: x, maxBackoff: maxBackoff, maxElapsed: maxElapsed}).Do(ctx, f)
+}
+
+// ErrDeadlineReached is returned, wrapped together with the last
+// error of f, when DoUntil reaches its deadline.
+var ErrDeadlineReached = errors.New("retry deadline reached")
+
+// DoUntil runs function f until f returns nil, or deadline has
+// passed, instead of for a number of retries. The backoff between the
+// attempts is the same as XWithContext's, but never sleeps past the
+// deadline, so the last attempt starts right at it. Once the deadline
+// has passed, the last error of f is returned wrapped with
+// ErrDeadlineReached. f is always called at least once, even if the
+// deadline has already passed, and an attempt that is running at the
+// deadline is allowed to complete. Use a ctx with the same deadline to
+// cut it short.
+//
+// Example 1:
+//    // Keep trying to acquire the lease until 10:00.
+//    err := retry.DoUntil(ctx, until, 5*time.Second, func(ctx context.Context) error {
+//        return AcquireLease(ctx)
+//    })
+//    if errors.Is(err, retry.ErrDeadlineReached) {
+//        // Didn't get the lease in time.
+//    }
+func DoUntil(ctx context.Context, deadline time.Time, maxBackoff time.Duration, f func(ctx context.Context) error) error {
+	return (&Retrier{retries: Infinite, maxBackoff: maxBackoff, until: deadline}).Do(ctx, f)
+}
+
+// XWithPredicate is like XWithContext, but only retries the errors
+// for which retryable returns true. Any other error from f is
+// returned immediately, without sleeping. A nil retryable retries
+// every error, the same as XWithContext.
+//
+// Example 1:
+//    retry.XWithPredicate(ctx, 3, 5*time.Second, IsTemporary, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+func XWithPredicate(ctx context.Context, x int, maxBackoff time.Duration, retryable func(error) bool, f func(ctx context.Context) error) error {
+	return (&Retrier{retries: x, maxBackoff: maxBackoff, retryable: retryable}).Do(ctx, f)
+}
+
+// XWithBackoffOverride is like XWithContext, but f also returns the
+// backoff before the next attempt, for example the wait a server asked
+// for. A positive duration replaces the computed backoff of the next
+// sleep, and zero keeps it. The duration is ignored when f succeeds.
+// It is the same as returning an error wrapped with RetryAfter.
+//
+// Example 1:
+//    retry.XWithBackoffOverride(ctx, 3, 5*time.Second, func(ctx context.Context) (time.Duration, error) {
+//        resp, err := Call(ctx)
+//        if err != nil {
+//            return resp.RetryAfter, err
+//        }
+//        return 0, nil
+//    })
+func XWithBackoffOverride(ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) (time.Duration, error)) error {
+	return withBackoffOverride(ctx, &Retrier{retries: x, maxBackoff: maxBackoff}, f)
+}
+
+func withBackoffOverride(ctx context.Context, r *Retrier, f func(ctx context.Context) (time.Duration, error)) error {
+	return r.Do(ctx, func(ctx context.Context) error {
+		d, err := f(ctx)
+		if err != nil && d > 0 {
+			return RetryAfter(d, err)
+		}
+		return err
+	})
+}
+
+// XWithContextHook is like XWithContext, but calls onRetry after
+// each failed attempt that will be followed by another one. The hook
+// gets the number of the failed attempt, starting at 1, its error
+// and the backoff that is about to be slept before the next attempt.
+// The hook is not called after the final failure.
+//
+// Example 1:
+//    retry.XWithContextHook(ctx, 3, 5*time.Second, func(attempt int, err error, next time.Duration) {
+//        log.Printf("attempt %d failed: %v, retrying in %v", attempt, err, next)
+//    }, func(ctx context.Context) error {
+//        return DoSomething(ctx)
+//    })
+func XWithContextHook(ctx context.Context, x int, maxBackoff time.Duration, onRetry func(attempt int, err error, nextBackoff time.Duration), f func(ctx context.Context) error) error {
+	return (&Retrier{retries: x, maxBackoff: maxBackoff, onRetry: onRetry}).Do(ctx, f)
+}
+
+// ErrConditionNotMet is the error of an attempt of XWithCondition
+// or DoWhile that succeeded, but wasn't done yet.
+var ErrConditionNotMet = errors.New("condition not met")
+
+// XWithCondition is like XWithContext, but f also reports if it is
+// done, for example when a job status call succeeds but the job is
+// still pending. The attempts are retried while f returns an error,
+// or isn't done, and stop once f is done without an error. If f never
+// got done, the *RetryError wraps the last error of f, or
+// ErrConditionNotMet when the last attempt succeeded but wasn't done.
+//
+// Example 1:
+//    err := retry.XWithCondition(ctx, 30, 2*time.Second, func(ctx context.Context) (bool, error) {
+//        status, err := GetJobStatus(ctx, id)
+//        return status == StatusDone, err
+//    })
+func XWithCondition(ctx context.Context, x int, maxBackoff time.Duration, f func(ctx context.Context) (done bool, err error)) error {
+	return XWithContext(ctx, x, maxBackoff, func(ctx context.Context) error {
+		done, err := f(ctx)
+		if err == nil && !done {
+			return ErrConditionNotMet
+		}
+		return err
+	})
+}
+
+// XConstant is like XWithContext, but sleeps exactly interval
+// between the attempts instead of ramping up an exponential backoff,
+// for example to poll a job status endpoint at a steady pace.
+//
+// Example 1:
+//    retry.XConstant(ctx, 30, 2*time.Second, func(ctx context.Context) error {
+//        return CheckJobDone(ctx)
+//    })
+func XConstant(ctx context.Context, x int, interval time.Duration, f func(ctx context.Context) error) error {
+	if interval < 0 {
+		return errors.New("interval cannot be less than 0")
+	}
+	return (&Retrier{retries: x, strategy: Constant(interval)}).Do(ctx, f)
+}
+
+// Schedule returns the sleeps between the attempts of X or
+// XWithContext with x retries and maxBackoff, including the jitter,
+// without running anything. There are x sleeps, as the first attempt
+// starts right away. Each call returns a different jitter, see
+// ScheduleNoJitter for a deterministic one. A negative maxBackoff is
+// taken as 0, so all the sleeps are 0.
+//
+// Example 1:
+//    var worst time.Duration
+//    for _, d := range retry.Schedule(6, 5*time.Second) {
+//        worst += d
+//    }
+func Schedule(x int, maxBackoff time.Duration) []time.Duration {
+	return schedule(x, exponential{max: maxBackoff})
+}
+
+// ScheduleNoJitter is like Schedule, but without the jitter, so the
+// sleeps are the exponential ramp up to maxBackoff, and then
+// maxBackoff, every time.
+func ScheduleNoJitter(x int, maxBackoff time.Duration) []time.Duration {
+	return schedule(x, exponential{max: maxBackoff, jitter: JitterNone})
+}
 
-		timer.Reset(backoff(i+1, maxBackoff))
+func schedule(x int, s exponential) []time.Duration {
+	if x < 0 {
+		x = 0
+	}
+	if s.max < 0 {
+		s.max = 0
+	}
+	sleeps := make([]time.Duration, x)
+	for i := range sleeps {
+		sleeps[i] = s.Backoff(i + 1)
 	}
-	// ran out of retries
-	return fmt.Errorf("%w", latestErr)
+	return sleeps
 }
 
 // backoff with exponential delay. On try 0, duration will be zero.
@@ -104,23 +503,57 @@ func XWithContext(ctx context.Context, x int, maxBackoff time.Duration, f func(c
 // Backoff is useful if you don't want to use the retry.X but want
 // to calculate exponential backoff with jitter for your own use.
 func backoff(try int, max time.Duration) time.Duration {
-	switch {
-	case try < 1:
-		return 0
-	case try > 3, max == 0:
-		return max
-	}
-
-	// 2^3 == 8. If you change this value then
-	// you need to update the documentation.
-	min := max / 8
-	jit := int64(min) * int64(try)
-	dur := min << uint64(try)
-	dur += time.Duration(rand.Int63n(jit))
+	return exponential{max: max}.Backoff(try)
+}
 
-	if dur < 0 || dur > max {
-		dur = max
+// BackoffBounds returns the range [lo, hi] of the backoff before try,
+// with the default jitter of X and XWithContext and maxBackoff, so a
+// test can assert on it despite the jitter: lo <= d && d <= hi. Try 0
+// is the first attempt, which starts right away.
+//
+// Example 1:
+//    lo, hi := retry.BackoffBounds(2, 8*time.Second)
+//    // lo is 4s, hi is just under 6s
+func BackoffBounds(try int, maxBackoff time.Duration) (lo, hi time.Duration) {
+	if try < 1 || maxBackoff <= 0 {
+		return 0, 0
+	}
+	base, unit, ramped := exponential{max: maxBackoff}.base(try)
+	if ramped {
+		return maxBackoff, maxBackoff
 	}
+	// the jitter is [0, unit*try)
+	return base, addCapped(base, unit*time.Duration(try)-1, maxBackoff)
+}
 
-	return dur
+// MaxTotalDelay returns the longest X or XWithContext with x retries
+// and maxBackoff can sleep in total, the sum of the upper bounds of
+// BackoffBounds for each of the x backoffs, for example to set the
+// timeout of a caller, or an alerting threshold. It excludes the time
+// f itself takes, so add x+1 times its latency for the full worst
+// case. It is deterministic, and saturates at the longest
+// time.Duration rather than overflowing. Like Schedule, it takes a
+// negative maxBackoff as 0.
+//
+// Example 1:
+//    // 17s less 2ns: just under 3s, 6s, then 8s.
+//    worst := retry.MaxTotalDelay(3, 8*time.Second)
+func MaxTotalDelay(x int, maxBackoff time.Duration) time.Duration {
+	if maxBackoff < 0 {
+		maxBackoff = 0
+	}
+	var total time.Duration
+	for try := 1; try <= x; try++ {
+		_, hi := BackoffBounds(try, maxBackoff)
+		if hi == maxBackoff {
+			// the rest are all maxBackoff
+			n := time.Duration(x - try + 1)
+			if maxBackoff > 0 && n > (maxDuration-total)/maxBackoff {
+				return maxDuration
+			}
+			return total + maxBackoff*n
+		}
+		total = addCapped(total, hi, maxDuration)
+	}
+	return total
 }
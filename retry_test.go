@@ -35,6 +35,56 @@ func TestXSuccess(t *testing.T) {
 	assert.Equal(t, 2, n)
 }
 
+func TestXNegative(t *testing.T) {
+	t.Parallel()
+
+	// A negative x should be clamped to 0, calling
+	// f exactly once, the same as x == 0.
+	for _, x := range []int{-5, -1, 0} {
+		n := 0
+		X(x, time.Millisecond, func() bool {
+			n++
+			return true
+		})
+		assert.Equal(t, 1, n, "x=%d", x)
+	}
+}
+
+func TestXContext(t *testing.T) {
+	t.Parallel()
+	n := 0
+	err := XContext(context.Background(), 4, time.Millisecond, func() bool {
+		n++
+		return n != 2
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n = 0
+	err = XContext(context.Background(), 4, time.Millisecond, func() bool {
+		n++
+		return true
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetries))
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 5, rerr.Attempts)
+	assert.Equal(t, 5, n)
+}
+
+func TestXContextCancelled(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithCancel(context.Background())
+	err := XContext(ctx, 4, time.Millisecond, func() bool {
+		n++
+		cancelFn()
+		return true
+	})
+	assert.Equal(t, 1, n)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
 func TestXWithContextFailure(t *testing.T) {
 	t.Parallel()
 	n := 0
@@ -162,3 +212,777 @@ func TestBackoffTail(t *testing.T) {
 		assert.Equal(t, third, backoff(i, max))
 	}
 }
+
+func TestDoSuccess(t *testing.T) {
+	t.Parallel()
+	n := 0
+	v, err := Do(context.Background(), 4, time.Millisecond, func(context.Context) (string, error) {
+		n++
+		if n == 3 {
+			return "ok", nil
+		}
+		return "partial", errors.New("oops")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", v)
+	assert.Equal(t, 3, n)
+}
+
+func TestDoFailure(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrOops = errors.New("oops")
+	v, err := Do(context.Background(), 2, time.Millisecond, func(context.Context) (int, error) {
+		n++
+		return n, fmt.Errorf("failure %v, %w", n, ErrOops)
+	})
+	assert.Equal(t, 3, n)
+	// The zero value is returned, not the value of the last attempt.
+	assert.Zero(t, v)
+	assert.True(t, errors.Is(err, ErrOops))
+}
+
+func TestDoCancelled(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithCancel(context.Background())
+	v, err := Do(ctx, 4, time.Millisecond, func(context.Context) (int, error) {
+		n++
+		cancelFn()
+		return n, errors.New("oops")
+	})
+	assert.Equal(t, 1, n)
+	assert.Zero(t, v)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestBackoffTinyMax(t *testing.T) {
+	t.Parallel()
+
+	// A max below 8ns makes max/8 zero, which must not
+	// panic when calculating the jitter.
+	for max := time.Duration(1); max < 8; max++ {
+		for i := 1; i <= 3; i++ {
+			assert.NotPanics(t, func() {
+				d := backoff(i, max)
+				assert.True(t, d >= 0 && d <= max)
+			})
+		}
+	}
+}
+
+func TestXWithDeadlineExhausted(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrOops = errors.New("oops")
+	// Plenty of retries, but a backoff that can't fit
+	// more than a couple of attempts into the budget.
+	err := XWithDeadline(context.Background(), 100, 20*time.Millisecond, 50*time.Millisecond, func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.True(t, n > 1 && n < 100, "n=%d", n)
+}
+
+func TestXWithDeadlineRetriesFirst(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrOops = errors.New("oops")
+	// The retries run out well before the budget does.
+	err := XWithDeadline(context.Background(), 2, time.Millisecond, time.Minute, func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.Equal(t, 3, n)
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.False(t, errors.Is(err, ErrBudgetExhausted))
+}
+
+func TestXWithDeadlineBadMaxElapsed(t *testing.T) {
+	t.Parallel()
+	n := 0
+	err := XWithDeadline(context.Background(), 2, time.Millisecond, 0, func(context.Context) error {
+		n++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Zero(t, n)
+}
+
+func TestXWithPredicate(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrTemporary = errors.New("temporary")
+	var ErrFatal = errors.New("fatal")
+	err := XWithPredicate(context.Background(), 4, time.Millisecond, func(err error) bool {
+		return errors.Is(err, ErrTemporary)
+	}, func(context.Context) error {
+		n++
+		if n == 2 {
+			return ErrFatal
+		}
+		return ErrTemporary
+	})
+	// The predicate rejected the second error.
+	assert.Equal(t, 2, n)
+	assert.True(t, errors.Is(err, ErrFatal))
+}
+
+func TestXWithPredicateNil(t *testing.T) {
+	t.Parallel()
+	n := 0
+	err := XWithPredicate(context.Background(), 4, time.Millisecond, nil, func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 5, n)
+}
+
+func TestXWithContextHook(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var attempts []int
+	var errs []error
+	err := XWithContextHook(context.Background(), 3, time.Millisecond, func(attempt int, err error, next time.Duration) {
+		attempts = append(attempts, attempt)
+		errs = append(errs, err)
+		assert.True(t, next > 0 && next <= time.Millisecond)
+	}, func(context.Context) error {
+		n++
+		return fmt.Errorf("failure %v", n)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 4, n)
+	// No hook after the final failure.
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+	assert.EqualError(t, errs[2], "failure 3")
+}
+
+func TestXWithContextHookSuccess(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	err := XWithContextHook(context.Background(), 3, time.Millisecond, func(int, error, time.Duration) {
+		calls++
+	}, func(context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, calls)
+}
+
+func TestXConstant(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrOops = errors.New("oops")
+	start := time.Now()
+	err := XConstant(context.Background(), 3, 5*time.Millisecond, func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.Equal(t, 4, n)
+	assert.True(t, errors.Is(err, ErrOops))
+	// Three sleeps of the interval, with no ramp-up.
+	assert.True(t, time.Since(start) >= 15*time.Millisecond)
+}
+
+func TestXConstantBadInterval(t *testing.T) {
+	t.Parallel()
+	n := 0
+	err := XConstant(context.Background(), 3, -1, func(context.Context) error {
+		n++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Zero(t, n)
+}
+
+func TestXWithContextDeadlineCapsBackoff(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelFn()
+
+	// A backoff of seconds would sleep past the deadline,
+	// but it's capped so a last attempt squeezes in.
+	start := time.Now()
+	err := XWithContext(ctx, 3, 10*time.Second, func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "err=%v", err)
+	assert.Equal(t, 2, n)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestXWithContextDeadlineClock(t *testing.T) {
+	t.Parallel()
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelFn()
+
+	// A Clock that runs ahead doesn't make the deadline of ctx pass.
+	clock := newFakeClock()
+	clock.now = time.Now().Add(time.Hour)
+	n := 0
+	err := New(WithMaxAttempts(5), WithClock(clock)).Do(ctx, func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded), "err=%v", err)
+	assert.False(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 5, n)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestXWithContextDeadlineKeepsInterval(t *testing.T) {
+	t.Parallel()
+	const interval = 100 * time.Millisecond
+	// gaps between the calls of f, which can't be shorter than the
+	// interval but for the last one, cut short by the deadline
+	check := func(name string, calls []time.Time) {
+		assert.True(t, len(calls) >= 2 && len(calls) <= 4, "%s: %d calls", name, len(calls))
+		for i := 1; i < len(calls)-1; i++ {
+			gap := calls[i].Sub(calls[i-1])
+			assert.True(t, gap >= interval, "%s: gap %d is %v", name, i, gap)
+		}
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 3*interval)
+	defer cancelFn()
+	var calls []time.Time
+	err := XConstant(ctx, Infinite, interval, func(context.Context) error {
+		calls = append(calls, time.Now())
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "err=%v", err)
+	check("XConstant", calls)
+
+	ctx, cancelFn = context.WithTimeout(context.Background(), 3*interval)
+	defer cancelFn()
+	calls = nil
+	err = PollUntil(ctx, interval, func(context.Context) (bool, error) {
+		calls = append(calls, time.Now())
+		return false, nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "err=%v", err)
+	check("PollUntil", calls)
+}
+
+func TestXWithContextDeadlinePassed(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelFn()
+
+	err := XWithContext(ctx, 3, 10*time.Second, func(context.Context) error {
+		n++
+		// Use up the time for another attempt.
+		time.Sleep(20 * time.Millisecond)
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, n)
+}
+
+func TestDoN(t *testing.T) {
+	t.Parallel()
+	n := 0
+	v, attempts, err := DoN(context.Background(), 4, time.Millisecond, func(context.Context) (string, error) {
+		n++
+		if n == 3 {
+			return "ok", nil
+		}
+		return "", errors.New("oops")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", v)
+	assert.Equal(t, 3, attempts)
+
+	v, attempts, err = DoN(context.Background(), 2, time.Millisecond, func(context.Context) (string, error) {
+		return "partial", errors.New("oops")
+	})
+	assert.Error(t, err)
+	assert.Zero(t, v)
+	assert.Equal(t, 3, attempts)
+
+	_, attempts, err = DoN(context.Background(), -1, time.Millisecond, func(context.Context) (string, error) {
+		return "", nil
+	})
+	assert.Error(t, err)
+	assert.Zero(t, attempts)
+}
+
+func TestXBool(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ok := XBool(context.Background(), 4, time.Millisecond, func(context.Context) bool {
+		n++
+		return n != 2
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 2, n)
+
+	n = 0
+	ok = XBool(context.Background(), 4, time.Millisecond, func(context.Context) bool {
+		n++
+		return true
+	})
+	assert.False(t, ok)
+	assert.Equal(t, 5, n)
+}
+
+func TestXBoolCancelled(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithCancel(context.Background())
+	ok := XBool(ctx, 4, time.Millisecond, func(context.Context) bool {
+		n++
+		cancelFn()
+		return true
+	})
+	assert.False(t, ok)
+	assert.Equal(t, 1, n)
+}
+
+func TestDoUntil(t *testing.T) {
+	t.Parallel()
+	n := 0
+	var ErrOops = errors.New("oops")
+	start := time.Now()
+	err := DoUntil(context.Background(), start.Add(50*time.Millisecond), 20*time.Millisecond, func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrDeadlineReached))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.True(t, n > 1, "n=%d", n)
+	assert.True(t, time.Since(start) < time.Second)
+
+	n = 0
+	err = DoUntil(context.Background(), time.Now().Add(time.Minute), time.Millisecond, func(context.Context) error {
+		n++
+		if n < 3 {
+			return ErrOops
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestDoUntilCapsBackoff(t *testing.T) {
+	t.Parallel()
+	n := 0
+	clock := newFakeClock()
+	r := &Retrier{
+		retries:    Infinite,
+		maxBackoff: 8 * time.Second,
+		jitter:     JitterNone,
+		clock:      clock,
+		until:      clock.Now().Add(10 * time.Second),
+	}
+	err := r.Do(context.Background(), func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrDeadlineReached))
+	// The third backoff of 8s is cut to the 4s left, and
+	// the last attempt runs right at the deadline.
+	assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second, 4 * time.Second}, clock.Sleeps())
+	assert.Equal(t, 4, n)
+}
+
+func TestDoUntilPassed(t *testing.T) {
+	t.Parallel()
+	n := 0
+	err := DoUntil(context.Background(), time.Now().Add(-time.Second), time.Millisecond, func(context.Context) error {
+		n++
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, ErrDeadlineReached))
+	assert.Equal(t, 1, n)
+}
+
+func TestXWithContextInfinite(t *testing.T) {
+	t.Parallel()
+	n := 0
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	err := XWithContext(ctx, Infinite, time.Millisecond, func(context.Context) error {
+		n++
+		if n == 20 {
+			cancelFn()
+		}
+		return errors.New("oops")
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 20, n)
+}
+
+func TestXWithCondition(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	err := XWithCondition(context.Background(), 4, time.Millisecond, func(context.Context) (bool, error) {
+		switch n++; n {
+		case 1:
+			return false, ErrOops
+		case 2:
+			return false, nil
+		case 3:
+			// An error wins over done.
+			return true, ErrOops
+		}
+		return true, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	n = 0
+	err = XWithCondition(context.Background(), 2, time.Millisecond, func(context.Context) (bool, error) {
+		n++
+		return false, nil
+	})
+	assert.True(t, errors.Is(err, ErrConditionNotMet))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 3, n)
+
+	err = XWithCondition(context.Background(), 2, time.Millisecond, func(context.Context) (bool, error) {
+		return false, ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.False(t, errors.Is(err, ErrConditionNotMet))
+}
+
+func TestXErr(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	err := XErr(4, time.Millisecond, func() error {
+		if n++; n < 2 {
+			return ErrOops
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n = 0
+	err = XErr(2, time.Millisecond, func() error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.Equal(t, 3, n)
+}
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	sleeps := Schedule(5, max)
+	assert.Len(t, sleeps, 5)
+	assert.True(t, sleeps[0] >= 2*time.Second && sleeps[0] < 3*time.Second, "d=%v", sleeps[0])
+	assert.True(t, sleeps[1] >= 4*time.Second && sleeps[1] < 6*time.Second, "d=%v", sleeps[1])
+	assert.Equal(t, []time.Duration{max, max, max}, sleeps[2:])
+
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, max, max, max}, ScheduleNoJitter(5, max))
+	assert.Empty(t, ScheduleNoJitter(0, max))
+	assert.Empty(t, Schedule(-1, max))
+
+	// A negative max is 0.
+	assert.Equal(t, []time.Duration{0, 0, 0}, Schedule(3, -time.Second))
+	assert.Equal(t, []time.Duration{0, 0, 0}, ScheduleNoJitter(3, -time.Second))
+}
+
+func TestXWithStop(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	stop := make(chan struct{})
+	err := XWithStop(stop, 4, time.Minute, func() error {
+		n++
+		close(stop)
+		return ErrOops
+	})
+	assert.Equal(t, ErrStopped, err)
+	assert.Equal(t, 1, n)
+
+	n = 0
+	err = XWithStop(make(chan struct{}), 4, time.Millisecond, func() error {
+		if n++; n < 3 {
+			return ErrOops
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// A nil channel never stops.
+	err = XWithStop(nil, 2, time.Millisecond, func() error {
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrOops))
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	f := Wrap(2, time.Millisecond, func(context.Context) error {
+		n++
+		return ErrOops
+	})
+	assert.True(t, errors.Is(f(context.Background()), ErrOops))
+	assert.Equal(t, 3, n)
+	// Each call retries on its own.
+	assert.True(t, errors.Is(f(context.Background()), ErrOops))
+	assert.Equal(t, 6, n)
+}
+
+func TestWrapValue(t *testing.T) {
+	t.Parallel()
+	n := 0
+	f := WrapValue(2, time.Millisecond, func(context.Context) (int, error) {
+		if n++; n%2 == 1 {
+			return 0, errors.New("oops")
+		}
+		return n, nil
+	})
+	v, err := f(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, v)
+	v, err = f(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, v)
+}
+
+func TestXWithContextAll(t *testing.T) {
+	t.Parallel()
+	msgs := []string{"dns", "timeout", "refused"}
+	n := 0
+	err, errs := XWithContextAll(context.Background(), 2, time.Millisecond, func(context.Context) error {
+		n++
+		return errors.New(msgs[n-1])
+	})
+	assert.EqualError(t, err, "failed after 3 attempts: refused")
+	assert.Len(t, errs, 3)
+	assert.Equal(t, 3, cap(errs))
+	for i, err := range errs {
+		assert.EqualError(t, err, msgs[i])
+	}
+
+	n = 0
+	err, errs = XWithContextAll(context.Background(), 2, time.Millisecond, func(context.Context) error {
+		if n++; n < 2 {
+			return errors.New("dns")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, errs, 1)
+}
+
+func TestXWithContextCancelledWrapsLastError(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	ctx, cancelFn := context.WithCancel(context.Background())
+	err := XWithContext(ctx, 4, time.Minute, func(context.Context) error {
+		cancelFn()
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(err, ErrOops))
+	var rerr *RetryError
+	assert.True(t, errors.As(err, &rerr))
+	assert.Equal(t, 1, rerr.Attempts)
+	assert.EqualError(t, err, "context canceled: failed after 1 attempts: oops")
+
+	// The deadline of ctx is told apart from a cancel.
+	ctx, cancelFn = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
+	err = XWithContext(ctx, 100, time.Second, func(context.Context) error {
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(err, ErrOops))
+
+	// Without a failed attempt, it is only the error of ctx.
+	ctx, cancelFn = context.WithCancel(context.Background())
+	cancelFn()
+	err = (&Retrier{retries: 1, initialDelay: time.Minute}).Do(ctx, func(context.Context) error {
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.As(err, &rerr))
+}
+
+func TestXWithContextCancelledDuringAttempt(t *testing.T) {
+	t.Parallel()
+	clock := newFakeClock()
+	retried := false
+	r := New(WithMaxBackoff(time.Minute), WithClock(clock), WithOnRetry(func(int, error, time.Duration) {
+		retried = true
+	}))
+	ctx, cancelFn := context.WithCancel(context.Background())
+	n := 0
+	err := r.Do(ctx, func(ctx context.Context) error {
+		n++
+		cancelFn()
+		return ctx.Err()
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, n)
+	// No backoff after the attempt.
+	assert.Equal(t, []time.Duration{0}, clock.Sleeps())
+	assert.False(t, retried)
+
+	// A per-attempt timeout is still retried.
+	n = 0
+	r = New(WithMaxAttempts(3), WithMaxBackoff(0), WithPerAttemptTimeout(time.Millisecond))
+	err = r.Do(context.Background(), func(ctx context.Context) error {
+		n++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, 3, n)
+}
+
+func TestXWithContextCancelCause(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	var ErrShutdown = errors.New("shutting down")
+	ctx, cancelFn := context.WithCancelCause(context.Background())
+	err := XWithContext(ctx, 4, time.Minute, func(context.Context) error {
+		cancelFn(ErrShutdown)
+		return ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrShutdown))
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.EqualError(t, err, "shutting down: failed after 1 attempts: oops")
+
+	// Without a cause, it is still the error of ctx.
+	ctx, cancelFn = context.WithCancelCause(context.Background())
+	cancelFn(nil)
+	err = (&Retrier{retries: 1, initialDelay: time.Minute}).Do(ctx, func(context.Context) error {
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestDoWhile(t *testing.T) {
+	t.Parallel()
+	statuses := []string{"PENDING", "PENDING", "DONE"}
+	n := 0
+	pending := func(status string, err error) bool {
+		return err != nil || status == "PENDING"
+	}
+	status, err := DoWhile(context.Background(), 4, time.Millisecond, func(context.Context) (string, error) {
+		n++
+		return statuses[n-1], nil
+	}, pending)
+	assert.NoError(t, err)
+	assert.Equal(t, "DONE", status)
+	assert.Equal(t, 3, n)
+
+	// The last result comes with the error on exhaustion.
+	status, err = DoWhile(context.Background(), 2, time.Millisecond, func(context.Context) (string, error) {
+		return "PENDING", nil
+	}, pending)
+	assert.True(t, errors.Is(err, ErrConditionNotMet))
+	assert.True(t, errors.Is(err, ErrMaxRetriesExceeded))
+	assert.Equal(t, "PENDING", status)
+
+	// An error that isn't retried is returned as is.
+	var ErrOops = errors.New("oops")
+	status, err = DoWhile(context.Background(), 2, time.Millisecond, func(context.Context) (string, error) {
+		return "FAILED", ErrOops
+	}, func(string, error) bool {
+		return false
+	})
+	assert.Equal(t, ErrOops, err)
+	assert.Equal(t, "FAILED", status)
+}
+
+func TestBackoffBounds(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+
+	lo, hi := BackoffBounds(2, max)
+	assert.Equal(t, 4*time.Second, lo)
+	assert.Equal(t, 6*time.Second-1, hi)
+	lo, hi = BackoffBounds(0, max)
+	assert.Zero(t, lo)
+	assert.Zero(t, hi)
+	lo, hi = BackoffBounds(5, max)
+	assert.Equal(t, max, lo)
+	assert.Equal(t, max, hi)
+
+	for _, max := range []time.Duration{0, 3, time.Millisecond, max, maxDuration} {
+		for try := 0; try < 10; try++ {
+			lo, hi := BackoffBounds(try, max)
+			for i := 0; i < 100; i++ {
+				d := backoff(try, max)
+				assert.True(t, lo <= d && d <= hi, "max=%v try=%d: %v not in [%v, %v]", max, try, d, lo, hi)
+			}
+		}
+	}
+}
+
+func TestMaxTotalDelay(t *testing.T) {
+	t.Parallel()
+	const max = 8 * time.Second
+	// Just under 3s and 6s, then 8s.
+	assert.Equal(t, 3*time.Second-1, MaxTotalDelay(1, max))
+	assert.Equal(t, 17*time.Second-2, MaxTotalDelay(3, max))
+	assert.Equal(t, 33*time.Second-2, MaxTotalDelay(5, max))
+	// 1.25s plus 625ms of jitter, 2.5s plus 1.25s, then 5s.
+	assert.Equal(t, (1875+3750+5000)*time.Millisecond-2, MaxTotalDelay(3, 5*time.Second))
+	assert.Zero(t, MaxTotalDelay(0, max))
+	assert.Zero(t, MaxTotalDelay(-1, max))
+	assert.Zero(t, MaxTotalDelay(10, 0))
+	assert.Zero(t, MaxTotalDelay(10, -time.Second))
+	assert.Equal(t, maxDuration, MaxTotalDelay(Infinite, max))
+
+	// It bounds the sleeps of every schedule.
+	for i := 0; i < 100; i++ {
+		var total time.Duration
+		for _, d := range Schedule(6, max) {
+			total += d
+		}
+		assert.True(t, total <= MaxTotalDelay(6, max), "total=%v", total)
+	}
+}
+
+func TestXWithBackoffOverride(t *testing.T) {
+	t.Parallel()
+	var ErrOops = errors.New("oops")
+	n := 0
+	clock := newFakeClock()
+	r := &Retrier{retries: 3, maxBackoff: 8 * time.Second, jitter: JitterNone, clock: clock}
+	err := withBackoffOverride(context.Background(), r, func(context.Context) (time.Duration, error) {
+		switch n++; n {
+		case 1:
+			return 2 * time.Second, ErrOops
+		case 2:
+			return 0, ErrOops
+		}
+		return time.Hour, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	// The override, then the computed backoff of the second retry.
+	assert.Equal(t, []time.Duration{0, 2 * time.Second, 4 * time.Second}, clock.Sleeps())
+
+	n = 0
+	err = XWithBackoffOverride(context.Background(), 2, time.Millisecond, func(context.Context) (time.Duration, error) {
+		n++
+		return time.Millisecond, ErrOops
+	})
+	assert.True(t, errors.Is(err, ErrOops))
+	assert.Equal(t, 3, n)
+}
@@ -0,0 +1,57 @@
+// Package retrygrpc classifies the errors of gRPC calls for the
+// predicates of the retry package, like XWithPredicate. It is a
+// module of its own, so the retry package stays free of the gRPC
+// dependency.
+//
+// Example:
+//     err := retry.XWithPredicate(ctx, 3, 5*time.Second, retrygrpc.IsRetryable, func(ctx context.Context) error {
+//         _, err := client.GetUser(ctx, req)
+//         return err
+//     })
+package retrygrpc
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IsRetryable reports if err is a gRPC status that is worth retrying
+// for any call: Unavailable and ResourceExhausted. Other codes, like
+// InvalidArgument or NotFound, and errors without a status are not.
+func IsRetryable(err error) bool {
+	return isCode(err, codes.Unavailable, codes.ResourceExhausted)
+}
+
+// IsRetryableIdempotent is like IsRetryable, but also retries
+// DeadlineExceeded, which is only safe for idempotent calls, as the
+// server may have completed a call whose response timed out.
+func IsRetryableIdempotent(err error) bool {
+	return isCode(err, codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded)
+}
+
+// Codes returns a predicate that reports if err is a gRPC status with
+// one of codes.
+//
+// Example 1:
+//    retryable := retrygrpc.Codes(codes.Unavailable, codes.Aborted)
+func Codes(codes ...codes.Code) func(err error) bool {
+	return func(err error) bool {
+		return isCode(err, codes...)
+	}
+}
+
+func isCode(err error, cs ...codes.Code) bool {
+	if err == nil {
+		return false
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	for _, c := range cs {
+		if s.Code() == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package retrygrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		err                   error
+		retryable, idempotent bool
+	}{
+		{status.Error(codes.Unavailable, "down"), true, true},
+		{status.Error(codes.ResourceExhausted, "slow down"), true, true},
+		{status.Error(codes.DeadlineExceeded, "late"), false, true},
+		{status.Error(codes.InvalidArgument, "bad"), false, false},
+		{status.Error(codes.NotFound, "gone"), false, false},
+		{fmt.Errorf("get user: %w", status.Error(codes.Unavailable, "down")), true, true},
+		{context.DeadlineExceeded, false, false},
+		{errors.New("oops"), false, false},
+		{nil, false, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.retryable, IsRetryable(tt.err), "err=%v", tt.err)
+		assert.Equal(t, tt.idempotent, IsRetryableIdempotent(tt.err), "err=%v", tt.err)
+	}
+}
+
+func TestCodes(t *testing.T) {
+	t.Parallel()
+	retryable := Codes(codes.Aborted, codes.Unavailable)
+	assert.True(t, retryable(status.Error(codes.Aborted, "conflict")))
+	assert.True(t, retryable(status.Error(codes.Unavailable, "down")))
+	assert.False(t, retryable(status.Error(codes.ResourceExhausted, "slow down")))
+	assert.False(t, retryable(errors.New("oops")))
+	assert.False(t, Codes()(status.Error(codes.Unavailable, "down")))
+}
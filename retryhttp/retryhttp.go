@@ -0,0 +1,182 @@
+// Package retryhttp is an HTTP client that retries with the policy of
+// a retry.Retrier: the connection errors and the retryable status
+// codes, honoring Retry-After, for the idempotent requests, rewinding
+// their bodies. It builds on the retry package, like retry.HTTPDo
+// does for a single call, for the code that wants a client of its own.
+//
+// Example:
+//     client := retryhttp.New(retryhttp.WithRetrier(retry.New(retry.WithMaxAttempts(5))))
+//     resp, err := client.Get("https://example.com/users/1")
+package retryhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/lytics/retry"
+	"github.com/lytics/retry/internal/httpretry"
+)
+
+// Client sends HTTP requests with the retries of a retry.Retrier, see
+// New. It is safe for concurrent use, if its Retrier is.
+type Client struct {
+	client    *http.Client
+	retrier   *retry.Retrier
+	retryable func(code int) bool
+	// retryAll retries the methods that aren't idempotent.
+	retryAll bool
+	// maxRetryAfter caps the Retry-After, zero means
+	// no cap.
+	maxRetryAfter time.Duration
+	onRetry       func(req *http.Request, attempt int, err error, next time.Duration)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// New returns a Client, which sends the requests with
+// http.DefaultClient, and retries them with retry.New, unless opts say
+// otherwise. It retries the connection errors of
+// retry.DefaultRetryable, and the status codes 429, 500, 502, 503 and
+// 504, like retry.HTTPDo.
+func New(opts ...Option) *Client {
+	c := &Client{client: http.DefaultClient, retrier: retry.New(), retryable: httpretry.RetryableStatus}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithHTTPClient sends the requests with client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithRetrier retries the requests with the attempts and the backoff
+// of r.
+func WithRetrier(r *retry.Retrier) Option {
+	return func(c *Client) {
+		c.retrier = r
+	}
+}
+
+// WithRetryableStatus only retries the responses with one of codes,
+// instead of the codes of retry.HTTPDo. The other responses are
+// returned as they are.
+//
+// Example 1:
+//    client := retryhttp.New(retryhttp.WithRetryableStatus(http.StatusServiceUnavailable))
+func WithRetryableStatus(codes ...int) Option {
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return func(c *Client) {
+		c.retryable = func(code int) bool { return set[code] }
+	}
+}
+
+// WithRetryNonIdempotent retries the requests of methods that aren't
+// idempotent, like POST, too. Only use it when the server can handle
+// a request that arrives twice.
+func WithRetryNonIdempotent() Option {
+	return func(c *Client) {
+		c.retryAll = true
+	}
+}
+
+// WithMaxRetryAfter caps the wait of a Retry-After header at d, see
+// retry.ClampRetryAfter, so a misbehaving server can't make the
+// client sleep for hours.
+func WithMaxRetryAfter(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetryAfter = d
+	}
+}
+
+// WithOnRetry sets a hook called before every retry of a request, like
+// retry.WithOnRetry, with the request too. It replaces the OnRetry
+// hook of the Retrier for the requests of the Client. Pause and Resume
+// of the Retrier still hold them.
+func WithOnRetry(onRetry func(req *http.Request, attempt int, err error, next time.Duration)) Option {
+	return func(c *Client) {
+		c.onRetry = onRetry
+	}
+}
+
+// Do sends req, and retries it with the semantics of retry.HTTPDo:
+// the body of req is rewound with req.GetBody for every attempt, and
+// a request with a body but no GetBody is only sent once, and so are
+// the requests that aren't idempotent, see WithRetryNonIdempotent. A
+// Retry-After header replaces the backoff before the next attempt.
+// The bodies of the responses of failed attempts are drained and
+// closed. If all attempts fail, the error unwraps to the last
+// *retry.HTTPStatusError, or the last error of the http.Client. The
+// retries stop when the context of req is done.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if !c.retryAll && !httpretry.Idempotent(req) || !httpretry.Resendable(req) {
+		// can't be sent again
+		return c.client.Do(req)
+	}
+	ctx := req.Context()
+	if c.onRetry != nil {
+		ctx = httpretry.WithOnRetry(ctx, c.retrier, func(attempt int, err error, next time.Duration) {
+			c.onRetry(req, attempt, err, next)
+		})
+	}
+	return httpretry.Do(ctx, req, httpretry.Policy{
+		Send: c.client.Do,
+		Retry: func(ctx context.Context, f func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+			return retry.DoValue(ctx, c.retrier, f)
+		},
+		Retryable: c.retryable,
+		Transient: retry.DefaultRetryable,
+		Permanent: retry.Permanent,
+		RetryAfter: func(d time.Duration, err error) error {
+			if c.maxRetryAfter > 0 {
+				d = retry.ClampRetryAfter(d, c.maxRetryAfter)
+			}
+			return retry.RetryAfter(d, err)
+		},
+		StatusError: func(resp *http.Response) error {
+			return &retry.HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		},
+		Exhausted: func(err error) bool {
+			return errors.Is(err, retry.ErrMaxRetriesExceeded)
+		},
+	})
+}
+
+// Get sends a GET request to url, see Do.
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post sends a POST request to url with body, of contentType, see Do.
+// The body is read into memory first, so it can be sent again, but
+// POST is only retried with WithRetryNonIdempotent.
+func (c *Client) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
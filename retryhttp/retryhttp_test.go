@@ -0,0 +1,224 @@
+package retryhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lytics/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// fast retries quickly, for the tests.
+var fast = WithRetrier(retry.New(retry.WithMaxAttempts(3), retry.WithMaxBackoff(time.Millisecond)))
+
+func TestClientDo(t *testing.T) {
+	t.Parallel()
+	var n int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	var retried []int
+	c := New(fast, WithHTTPClient(srv.Client()), WithOnRetry(func(req *http.Request, attempt int, err error, _ time.Duration) {
+		assert.Equal(t, http.MethodPut, req.Method)
+		retried = append(retried, attempt)
+	}))
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("payload"))
+	resp, err := c.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "ok", string(b))
+	// The body was rewound for every attempt.
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+	assert.Equal(t, []int{1, 2}, retried)
+}
+
+func TestClientExhausted(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	resp, err := New(fast, WithHTTPClient(srv.Client())).Get(srv.URL)
+	assert.Nil(t, resp)
+	assert.Equal(t, int32(3), n)
+	assert.True(t, errors.Is(err, retry.ErrMaxRetriesExceeded))
+	var serr *retry.HTTPStatusError
+	assert.True(t, errors.As(err, &serr))
+	assert.Equal(t, http.StatusBadGateway, serr.StatusCode)
+}
+
+func TestClientRetryableStatus(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	// 409 isn't retried by default.
+	resp, err := New(fast, WithHTTPClient(srv.Client())).Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, int32(1), n)
+
+	atomic.StoreInt32(&n, 0)
+	_, err = New(fast, WithHTTPClient(srv.Client()), WithRetryableStatus(http.StatusConflict)).Get(srv.URL)
+	assert.True(t, errors.Is(err, retry.ErrMaxRetriesExceeded))
+	assert.Equal(t, int32(3), n)
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var next time.Duration
+	c := New(fast, WithHTTPClient(srv.Client()), WithMaxRetryAfter(20*time.Millisecond), WithOnRetry(func(_ *http.Request, _ int, _ error, d time.Duration) {
+		next = d
+	}))
+	resp, err := c.Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	// The hour of Retry-After is capped.
+	assert.Equal(t, 20*time.Millisecond, next)
+}
+
+func TestClientPause(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+	}))
+	defer srv.Close()
+
+	// The hook of the client doesn't escape the Pause of its Retrier.
+	r := retry.New(retry.WithMaxAttempts(3), retry.WithMaxBackoff(time.Millisecond))
+	c := New(WithRetrier(r), WithHTTPClient(srv.Client()), WithOnRetry(func(*http.Request, int, error, time.Duration) {}))
+	r.Pause()
+	done := make(chan error)
+	go func() {
+		resp, err := c.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Do returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Zero(t, atomic.LoadInt32(&n))
+
+	r.Resume()
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+}
+
+func TestClientError(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// An error of the http.Client other than a connection error
+	// isn't retried.
+	errNoRedirect := errors.New("no redirect")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return errNoRedirect
+	}}
+	resp, err := New(fast, WithHTTPClient(client)).Get(srv.URL)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	assert.True(t, errors.Is(err, errNoRedirect), "err=%v", err)
+	assert.Equal(t, int32(1), n)
+}
+
+func TestClientNonIdempotent(t *testing.T) {
+	t.Parallel()
+	var n int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// A POST is only sent once.
+	resp, err := New(fast, WithHTTPClient(srv.Client())).Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), n)
+
+	// Unless asked to, and its body is read again.
+	atomic.StoreInt32(&n, 0)
+	bodies = nil
+	_, err = New(fast, WithHTTPClient(srv.Client()), WithRetryNonIdempotent()).Post(srv.URL, "text/plain", io.NopCloser(strings.NewReader("payload")))
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), n)
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+
+	// Or with an Idempotency-Key.
+	atomic.StoreInt32(&n, 0)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	req.Header.Set("Idempotency-Key", "42")
+	_, err = New(fast, WithHTTPClient(srv.Client())).Do(req)
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), n)
+}
+
+func TestClientCancelled(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := New(WithRetrier(retry.New(retry.WithMaxAttempts(retry.Infinite), retry.WithMaxBackoff(time.Minute))), WithHTTPClient(srv.Client()))
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Do(req.WithContext(ctx))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "err=%v", err)
+	assert.True(t, time.Since(start) < time.Minute)
+}
@@ -0,0 +1,50 @@
+package retry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Tx runs fn in a transaction of db and commits it, retrying the
+// whole transaction on serialization failures, with the semantics of
+// XWithContext. A serialization failure is an error with the SQLSTATE
+// 40001, which Postgres and CockroachDB return for transactions that
+// must be replayed; it is detected through a SQLState() string method,
+// like the one of the errors of pgx and lib/pq. Other errors are
+// returned immediately. After a failed fn the transaction is rolled
+// back before retrying or returning.
+//
+// Example 1:
+//    err := retry.Tx(ctx, db, 3, time.Second, func(tx *sql.Tx) error {
+//        _, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance - 10 WHERE id = $1", id)
+//        return err
+//    })
+func Tx(ctx context.Context, db *sql.DB, x int, maxBackoff time.Duration, fn func(*sql.Tx) error) error {
+	return TxWithPredicate(ctx, db, x, maxBackoff, IsSerializationFailure, fn)
+}
+
+// TxWithPredicate is like Tx, but retries the errors for which
+// retryable returns true, since which errors are retryable depends on
+// the driver. A nil retryable retries every error.
+func TxWithPredicate(ctx context.Context, db *sql.DB, x int, maxBackoff time.Duration, retryable func(error) bool, fn func(*sql.Tx) error) error {
+	return (&Retrier{retries: x, maxBackoff: maxBackoff, retryable: retryable}).Do(ctx, func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// IsSerializationFailure reports if err, or an error in its chain,
+// has a SQLState() method that returns 40001.
+func IsSerializationFailure(err error) bool {
+	var sqlErr interface{ SQLState() string }
+	return errors.As(err, &sqlErr) && sqlErr.SQLState() == "40001"
+}
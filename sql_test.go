@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDB is a database/sql driver that only counts transactions.
+type fakeDB struct {
+	mu                 sync.Mutex
+	commits, rollbacks int
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx(c), nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+// sqlStateError is an error of a driver with a SQLSTATE.
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sqlstate " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestTx(t *testing.T) {
+	t.Parallel()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	n := 0
+	err := Tx(context.Background(), db, 3, time.Millisecond, func(*sql.Tx) error {
+		n++
+		if n < 3 {
+			return fmt.Errorf("update: %w", sqlStateError("40001"))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 1, fake.commits)
+	assert.Equal(t, 2, fake.rollbacks)
+}
+
+func TestTxNotRetryable(t *testing.T) {
+	t.Parallel()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	n := 0
+	err := Tx(context.Background(), db, 3, time.Millisecond, func(*sql.Tx) error {
+		n++
+		// unique_violation
+		return sqlStateError("23505")
+	})
+	assert.Equal(t, sqlStateError("23505"), err)
+	assert.Equal(t, 1, n)
+	assert.Zero(t, fake.commits)
+	assert.Equal(t, 1, fake.rollbacks)
+}
+
+func TestTxWithPredicate(t *testing.T) {
+	t.Parallel()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	var ErrBusy = errors.New("database is locked")
+	n := 0
+	err := TxWithPredicate(context.Background(), db, 3, time.Millisecond, func(err error) bool {
+		return errors.Is(err, ErrBusy)
+	}, func(*sql.Tx) error {
+		n++
+		if n < 2 {
+			return ErrBusy
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, fake.commits)
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	t.Parallel()
+	assert.True(t, IsSerializationFailure(sqlStateError("40001")))
+	assert.True(t, IsSerializationFailure(fmt.Errorf("commit: %w", sqlStateError("40001"))))
+	assert.False(t, IsSerializationFailure(sqlStateError("40P01")))
+	assert.False(t, IsSerializationFailure(errors.New("40001")))
+}
@@ -0,0 +1,58 @@
+package retry
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lytics/retry/internal/httpretry"
+)
+
+// NewTransport returns a RoundTripper that retries the requests sent
+// with base, or http.DefaultTransport if base is nil, like HTTPDo, so
+// every request of an http.Client retries once it is set as the
+// client's Transport. Only the idempotent methods GET, HEAD, OPTIONS,
+// TRACE, PUT and DELETE are retried, and requests with an
+// Idempotency-Key header, unless WithRetryNonIdempotent is given.
+// When all attempts get a retryable status code, the response of the
+// last one is returned, as without the retries, and the bodies of the
+// others are drained and closed.
+//
+// Example 1:
+//    client := &http.Client{
+//        Transport: retry.NewTransport(nil, 3, 5*time.Second),
+//    }
+func NewTransport(base http.RoundTripper, x int, maxBackoff time.Duration, opts ...HTTPOption) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &transport{base: base, x: x, maxBackoff: maxBackoff}
+	for _, opt := range opts {
+		opt(&t.c)
+	}
+	t.c.keepLast = true
+	return t
+}
+
+// WithRetryNonIdempotent makes a Transport retry the requests of
+// methods that aren't idempotent, like POST, too. Only use it when the
+// server can handle a request that arrives twice. HTTPDo always
+// retries, whatever the method.
+func WithRetryNonIdempotent() HTTPOption {
+	return func(c *httpConfig) {
+		c.retryAll = true
+	}
+}
+
+type transport struct {
+	base       http.RoundTripper
+	x          int
+	maxBackoff time.Duration
+	c          httpConfig
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.c.retryAll && !httpretry.Idempotent(req) {
+		return t.base.RoundTrip(req)
+	}
+	return httpRetry(req.Context(), req, t.x, t.maxBackoff, t.c, t.base.RoundTrip)
+}
@@ -0,0 +1,91 @@
+package retry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(srv.Client().Transport, 3, 0)}
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("hello"))
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	b, _ := io.ReadAll(resp.Body)
+	// The body is sent again with every attempt.
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+}
+
+func TestTransportExhausted(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("bad gateway"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(srv.Client().Transport, 2, 0)}
+	resp, err := client.Get(srv.URL)
+	// The last response is returned, as without the retries.
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	b, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "bad gateway", string(b))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+}
+
+func TestTransportNonIdempotent(t *testing.T) {
+	t.Parallel()
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(srv.Client().Transport, 2, 0)}
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+
+	// Unless it has an idempotency key.
+	atomic.StoreInt32(&n, 0)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"))
+	req.Header.Set("Idempotency-Key", "42")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+
+	// Or the caller opts in.
+	atomic.StoreInt32(&n, 0)
+	client = &http.Client{Transport: NewTransport(srv.Client().Transport, 2, 0, WithRetryNonIdempotent())}
+	resp, err = client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+}